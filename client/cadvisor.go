@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CadvisorStats are the container figures of a node's cAdvisor metrics (/metrics/cadvisor)
+type CadvisorStats struct {
+	Containers []ContainerStats `json:"containers"`
+}
+
+// ContainerStats are the cAdvisor figures of one container
+type ContainerStats struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// CFSPeriods and CFSThrottledPeriods are cumulative counters, zero for
+	// containers without a CPU limit
+	CFSPeriods          float64 `json:"cfsPeriods,omitempty"`
+	CFSThrottledPeriods float64 `json:"cfsThrottledPeriods,omitempty"`
+	// RSS, Cache and WorkingSet are memory in bytes
+	RSS        float64 `json:"rss"`
+	Cache      float64 `json:"cache"`
+	WorkingSet float64 `json:"workingSet"`
+}
+
+// cadvisorFields maps the metrics xtop reads to the field they fill
+var cadvisorFields = map[string]func(*ContainerStats) *float64{
+	"container_cpu_cfs_periods_total":           func(c *ContainerStats) *float64 { return &c.CFSPeriods },
+	"container_cpu_cfs_throttled_periods_total": func(c *ContainerStats) *float64 { return &c.CFSThrottledPeriods },
+	"container_memory_rss":                      func(c *ContainerStats) *float64 { return &c.RSS },
+	"container_memory_cache":                    func(c *ContainerStats) *float64 { return &c.Cache },
+	"container_memory_working_set_bytes":        func(c *ContainerStats) *float64 { return &c.WorkingSet },
+}
+
+// parseCadvisor reads the container series of the metrics xtop uses from the
+// Prometheus text format. Series of the pod cgroup and the pause container
+// carry no container name, or POD, and are skipped.
+func parseCadvisor(data []byte) (*CadvisorStats, error) {
+	stats := &CadvisorStats{}
+	index := make(map[[3]string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		nameEnd := strings.IndexAny(line, "{ ")
+		if nameEnd < 0 {
+			continue
+		}
+		field, ok := cadvisorFields[line[:nameEnd]]
+		if !ok {
+			continue
+		}
+
+		labels, rest, err := parseLabels(line[nameEnd:])
+		if err != nil {
+			return nil, fmt.Errorf("cadvisor metrics line %d: %w", lineNo, err)
+		}
+		container := labels["container"]
+		if container == "" || container == "POD" || labels["pod"] == "" {
+			continue
+		}
+		// A sample is the value, optionally followed by a timestamp
+		valueText, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		value, err := strconv.ParseFloat(valueText, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cadvisor metrics line %d: %w", lineNo, err)
+		}
+
+		key := [3]string{labels["namespace"], labels["pod"], container}
+		i, ok := index[key]
+		if !ok {
+			i = len(stats.Containers)
+			index[key] = i
+			stats.Containers = append(stats.Containers, ContainerStats{Namespace: key[0], Pod: key[1], Container: key[2]})
+		}
+		*field(&stats.Containers[i]) = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// parseLabels parses an optional {name="value",...} label set at the start of
+// s and returns the labels and what follows them
+func parseLabels(s string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	if !strings.HasPrefix(s, "{") {
+		return labels, s, nil
+	}
+	s = s[1:]
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok || !strings.HasPrefix(rest, `"`) {
+			return nil, "", fmt.Errorf("malformed label set")
+		}
+		var value strings.Builder
+		i := 1
+		for ; i < len(rest) && rest[i] != '"'; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				if rest[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(rest[i])
+		}
+		if i >= len(rest) {
+			return nil, "", fmt.Errorf("unterminated label value")
+		}
+		labels[strings.TrimSpace(name)] = value.String()
+		s = rest[i+1:]
+	}
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// nodeWorkers bounds the concurrent node proxy requests of FetchKubeletConfigs,
+// FetchStatsSummaries and FetchCadvisorStats
+const nodeWorkers = 16
+
+// FetchRequest selects what FetchState reads from a Source
+type FetchRequest struct {
+	Nodes bool
+	Pods  bool
+	// Namespace limits pods to one namespace; empty means all namespaces
+	Namespace   string
+	PodMetrics  bool
+	NodeMetrics bool
+	// PodDisruptionBudgets are always read from all namespaces
+	PodDisruptionBudgets bool
+	// LimitRanges, HorizontalPodAutoscalers, Workloads, VerticalPodAutoscalers
+	// and PersistentVolumeClaims are read from the same namespace as pods
+	LimitRanges              bool
+	HorizontalPodAutoscalers bool
+	Workloads                bool
+	VerticalPodAutoscalers   bool
+	PersistentVolumeClaims   bool
+}
+
+// ClusterState holds the objects read by FetchState
+type ClusterState struct {
+	Nodes       []v1.Node
+	Pods        []v1.Pod
+	PodMetrics  *metricsv1beta1.PodMetricsList
+	NodeMetrics *metricsv1beta1.NodeMetricsList
+	LimitRanges []v1.LimitRange
+	// PodDisruptionBudgets, HorizontalPodAutoscalers, Workloads,
+	// VerticalPodAutoscalers and PersistentVolumeClaims are only read on request
+	PodDisruptionBudgets     []policyv1.PodDisruptionBudget
+	HorizontalPodAutoscalers []autoscalingv2.HorizontalPodAutoscaler
+	Workloads                []Workload
+	VerticalPodAutoscalers   []VerticalPodAutoscaler
+	PersistentVolumeClaims   []v1.PersistentVolumeClaim
+	// MetricsErr is set when metrics could not be read. Metrics are optional,
+	// so this does not fail the fetch.
+	MetricsErr error
+	// LimitRangesErr is set when LimitRanges could not be read, which needs
+	// RBAC many users lack. Like metrics it does not fail the fetch.
+	LimitRangesErr error
+}
+
+// FetchState reads the requested objects and metrics concurrently. Any failing
+// call except metrics and LimitRanges cancels the remaining calls and is returned.
+func FetchState(ctx context.Context, src Source, req FetchRequest) (*ClusterState, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	state := &ClusterState{}
+	var (
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		firstErr  error
+		metricsMu sync.Mutex
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	if req.Nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodes, err := src.Nodes(ctx)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.Nodes = nodes
+		}()
+	}
+	if req.Pods {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pods, err := src.Pods(ctx, req.Namespace)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.Pods = pods
+		}()
+	}
+	if req.PodDisruptionBudgets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pdbs, err := src.PodDisruptionBudgets(ctx)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.PodDisruptionBudgets = pdbs
+		}()
+	}
+	if req.LimitRanges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limitRanges, err := src.LimitRanges(ctx, req.Namespace)
+			state.LimitRanges = limitRanges
+			state.LimitRangesErr = err
+		}()
+	}
+	if req.HorizontalPodAutoscalers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hpas, err := src.HorizontalPodAutoscalers(ctx, req.Namespace)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.HorizontalPodAutoscalers = hpas
+		}()
+	}
+	if req.Workloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workloads, err := src.Workloads(ctx, req.Namespace)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.Workloads = workloads
+		}()
+	}
+	if req.VerticalPodAutoscalers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vpas, err := src.VerticalPodAutoscalers(ctx, req.Namespace)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.VerticalPodAutoscalers = vpas
+		}()
+	}
+	if req.PersistentVolumeClaims {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pvcs, err := src.PersistentVolumeClaims(ctx, req.Namespace)
+			if err != nil {
+				fail(err)
+				return
+			}
+			state.PersistentVolumeClaims = pvcs
+		}()
+	}
+	if req.PodMetrics {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			podMetrics, err := src.PodMetrics(ctx)
+			metricsMu.Lock()
+			defer metricsMu.Unlock()
+			state.PodMetrics = podMetrics
+			if err != nil {
+				state.MetricsErr = err
+			}
+		}()
+	}
+	if req.NodeMetrics {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeMetrics, err := src.NodeMetrics(ctx)
+			metricsMu.Lock()
+			defer metricsMu.Unlock()
+			state.NodeMetrics = nodeMetrics
+			if err != nil {
+				state.MetricsErr = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return state, nil
+}
+
+// FetchKubeletConfigs reads the kubelet config of every node. Kubelets may be
+// unreachable, so a failing node does not stop the others: it is left out of
+// the result and reported in the returned error.
+func FetchKubeletConfigs(ctx context.Context, src Source, nodeNames []string) (map[string]*KubeletConfig, error) {
+	return fetchPerNode(ctx, nodeNames, "kubelet config", src.KubeletConfig)
+}
+
+// FetchStatsSummaries reads the kubelet summary API of every node, leaving out
+// and reporting the nodes that fail like FetchKubeletConfigs
+func FetchStatsSummaries(ctx context.Context, src Source, nodeNames []string) (map[string]*StatsSummary, error) {
+	return fetchPerNode(ctx, nodeNames, "kubelet stats", src.StatsSummary)
+}
+
+// FetchCadvisorStats reads the cAdvisor metrics of every node, leaving out
+// and reporting the nodes that fail like FetchKubeletConfigs
+func FetchCadvisorStats(ctx context.Context, src Source, nodeNames []string) (map[string]*CadvisorStats, error) {
+	return fetchPerNode(ctx, nodeNames, "cadvisor metrics", src.CadvisorStats)
+}
+
+// fetchPerNode calls fetch for every node with a bounded pool of workers
+func fetchPerNode[T any](ctx context.Context, nodeNames []string, what string, fetch func(context.Context, string) (*T, error)) (map[string]*T, error) {
+	names := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   int
+		firstErr error
+	)
+	results := make(map[string]*T, len(nodeNames))
+
+	for i := 0; i < min(nodeWorkers, len(nodeNames)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				result, err := fetch(ctx, name)
+				mu.Lock()
+				if err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					results[name] = result
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range nodeNames {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	if failed > 0 {
+		return results, fmt.Errorf("%s of %d of %d nodes not available: %w", what, failed, len(nodeNames), firstErr)
+	}
+	return results, nil
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+const (
+	benchNodes = 500
+	benchPods  = 10000
+	// The fake API server answers in roundTrip plus perObject for every
+	// object returned, roughly what a real server spends per object
+	roundTrip = 200 * time.Microsecond
+	perObject = 20 * time.Microsecond
+)
+
+// syntheticNode carries the bulky parts real nodes have, like the image list
+func syntheticNode(i int) v1.Node {
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:          fmt.Sprintf("node-%d", i),
+			Labels:        map[string]string{"node.kubernetes.io/instance-type": "m5.xlarge"},
+			Annotations:   map[string]string{"node.alpha.kubernetes.io/ttl": "0", "volumes.kubernetes.io/controller-managed-attach-detach": "true"},
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet", FieldsV1: &metav1.FieldsV1{Raw: make([]byte, 4096)}}},
+		},
+		Status: v1.NodeStatus{
+			Capacity:    v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("16Gi")},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("16Gi")},
+		},
+	}
+	for j := 0; j < 50; j++ {
+		node.Status.Images = append(node.Status.Images, v1.ContainerImage{
+			Names:     []string{fmt.Sprintf("registry.example.com/team/app-%d@sha256:%064d", j, j)},
+			SizeBytes: 100 << 20,
+		})
+	}
+	return node
+}
+
+// syntheticPod carries the bulky parts real pods have, like env and managed fields
+func syntheticPod(i int) v1.Pod {
+	container := v1.Container{
+		Name:    "app",
+		Image:   "registry.example.com/team/app:1.0",
+		Command: []string{"/app", "--config", "/etc/app/config.yaml"},
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("128Mi")},
+			Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m"), v1.ResourceMemory: resource.MustParse("512Mi")},
+		},
+		LivenessProbe: &v1.Probe{ProbeHandler: v1.ProbeHandler{HTTPGet: &v1.HTTPGetAction{Path: "/healthz"}}},
+	}
+	for j := 0; j < 20; j++ {
+		container.Env = append(container.Env, v1.EnvVar{Name: "SETTING_" + strconv.Itoa(j), Value: "some-reasonably-long-configuration-value"})
+	}
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:          fmt.Sprintf("app-%d", i),
+			Namespace:     fmt.Sprintf("team-%d", i%20),
+			Labels:        map[string]string{"app": "app", "pod-template-hash": "5d8f7c9b6"},
+			Annotations:   map[string]string{"kubectl.kubernetes.io/last-applied-configuration": string(make([]byte, 2048))},
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kube-controller-manager", FieldsV1: &metav1.FieldsV1{Raw: make([]byte, 2048)}}},
+		},
+		Spec: v1.PodSpec{
+			NodeName:   fmt.Sprintf("node-%d", i%benchNodes),
+			Containers: []v1.Container{container},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// newBenchSource returns a liveSource backed by fake clientsets that serve
+// benchNodes nodes and benchPods pods, honoring Limit and Continue
+func newBenchSource() *liveSource {
+	nodes := make([]v1.Node, benchNodes)
+	for i := range nodes {
+		nodes[i] = syntheticNode(i)
+	}
+	pods := make([]v1.Pod, benchPods)
+	podMetrics := &metricsv1beta1.PodMetricsList{Items: make([]metricsv1beta1.PodMetrics, benchPods)}
+	for i := range pods {
+		pods[i] = syntheticPod(i)
+		podMetrics.Items[i] = metricsv1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: pods[i].Name, Namespace: pods[i].Namespace},
+			Containers: []metricsv1beta1.ContainerMetrics{{
+				Name:  "app",
+				Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("50m"), v1.ResourceMemory: resource.MustParse("100Mi")},
+			}},
+		}
+	}
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		start, end, next := page(action, len(nodes))
+		list := &v1.NodeList{ListMeta: metav1.ListMeta{Continue: next}}
+		for i := start; i < end; i++ {
+			list.Items = append(list.Items, *nodes[i].DeepCopy())
+		}
+		return true, list, nil
+	})
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		start, end, next := page(action, len(pods))
+		list := &v1.PodList{ListMeta: metav1.ListMeta{Continue: next}}
+		for i := start; i < end; i++ {
+			list.Items = append(list.Items, *pods[i].DeepCopy())
+		}
+		return true, list, nil
+	})
+
+	metricsClient := metricsfake.NewSimpleClientset()
+	metricsClient.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		time.Sleep(roundTrip + time.Duration(len(podMetrics.Items))*perObject)
+		return true, podMetrics.DeepCopy(), nil
+	})
+
+	return &liveSource{clientset: clientset, metrics: metricsClient}
+}
+
+// page picks the slice of total objects a list call asks for and simulates
+// the time the server takes to return it
+func page(action k8stesting.Action, total int) (start, end int, next string) {
+	opts := action.(k8stesting.ListActionImpl).ListOptions
+	if opts.Continue != "" {
+		start, _ = strconv.Atoi(opts.Continue)
+	}
+	end = total
+	if opts.Limit > 0 && start+int(opts.Limit) < total {
+		end = start + int(opts.Limit)
+		next = strconv.Itoa(end)
+	}
+	time.Sleep(roundTrip + time.Duration(end-start)*perObject)
+	return start, end, next
+}
+
+// fetchSequential is how the views used to read state: one unpaginated list
+// after the other, keeping whole objects
+func fetchSequential(ctx context.Context, src *liveSource) (*ClusterState, error) {
+	nodes, err := src.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := src.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	podMetrics, err := src.PodMetrics(ctx)
+	return &ClusterState{Nodes: nodes.Items, Pods: pods.Items, PodMetrics: podMetrics, MetricsErr: err}, nil
+}
+
+// retainedBytes reports how much heap the state returned by fetch keeps alive
+func retainedBytes(b *testing.B, fetch func() (*ClusterState, error)) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	state, err := fetch()
+	if err != nil {
+		b.Fatal(err)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(state)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+func benchmarkFetch(b *testing.B, fetch func(ctx context.Context, src *liveSource) (*ClusterState, error)) {
+	src := newBenchSource()
+	ctx := context.Background()
+	retained := retainedBytes(b, func() (*ClusterState, error) { return fetch(ctx, src) })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		state, err := fetch(ctx, src)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(state.Pods) != benchPods || len(state.Nodes) != benchNodes {
+			b.Fatalf("got %d pods and %d nodes", len(state.Pods), len(state.Nodes))
+		}
+	}
+	b.ReportMetric(float64(retained)/(1<<20), "retained-MiB")
+}
+
+func BenchmarkFetchSequential(b *testing.B) {
+	benchmarkFetch(b, fetchSequential)
+}
+
+func BenchmarkFetchState(b *testing.B) {
+	benchmarkFetch(b, func(ctx context.Context, src *liveSource) (*ClusterState, error) {
+		return FetchState(ctx, src, FetchRequest{Nodes: true, Pods: true, PodMetrics: true})
+	})
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"sort"
+
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -11,22 +13,74 @@ var (
 	Config    *rest.Config
 )
 
-func init() {
-	// Use the default kubeconfig loading rules
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	configOverrides := &clientcmd.ConfigOverrides{}
-	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-
+// Init loads the kubeconfig and creates the clientset. It is only needed when
+// talking to a live cluster, so offline commands work without a kubeconfig.
+func Init() error {
 	// Get a rest.Config from the kubeconfig file
 	var err error
-	Config, err = kubeConfig.ClientConfig()
+	Config, err = kubeClientConfig("").ClientConfig()
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 
 	// Create the clientset
-	Clientset, err = kubernetes.NewForConfig(Config)
+	Clientset, err = kubernetes.NewForConfig(protobufConfig(Config))
+	return err
+}
+
+// protobufConfig asks the API server for protobuf, which is several times
+// cheaper to decode than JSON on large lists. Built-in types all support it.
+func protobufConfig(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.AcceptContentTypes = "application/vnd.kubernetes.protobuf,application/json"
+	config.ContentType = "application/vnd.kubernetes.protobuf"
+	return config
+}
+
+// InitInCluster uses the pod's service account when running inside a cluster
+// and falls back to the kubeconfig otherwise
+func InitInCluster() error {
+	var err error
+	Config, err = rest.InClusterConfig()
+	if err == rest.ErrNotInCluster {
+		return Init()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Create the clientset
+	Clientset, err = kubernetes.NewForConfig(protobufConfig(Config))
+	return err
+}
+
+// kubeClientConfig uses the default kubeconfig loading rules, switching to
+// contextName when it is not empty
+func kubeClientConfig(contextName string) clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+}
+
+// Contexts returns the names of all kubeconfig contexts, sorted
+func Contexts() ([]string, error) {
+	raw, err := kubeClientConfig("").RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(raw.Contexts))
+	for name := range raw.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CurrentContext returns the kubeconfig current-context, or "" when there is no kubeconfig
+func CurrentContext() string {
+	raw, err := kubeClientConfig("").RawConfig()
 	if err != nil {
-		panic(err.Error())
+		return ""
 	}
+	return raw.CurrentContext
 }
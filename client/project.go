@@ -0,0 +1,110 @@
+package client
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// listPageSize is the number of objects requested per List call, so large
+// clusters are read in chunks instead of one huge response
+const listPageSize = 500
+
+// FullObjects disables the projection of listed nodes and pods, for callers
+// that read arbitrary fields of them
+var FullObjects bool
+
+// AuditIgnoreAnnotation lists the audit rules suppressed for a pod, or "all"
+const AuditIgnoreAnnotation = "xtop/audit-ignore"
+
+// LimitRangerAnnotation records the requests and limits LimitRange admission set on a pod
+const LimitRangerAnnotation = "kubernetes.io/limit-ranger"
+
+// keptAnnotations are the only annotations xtop looks at
+var keptAnnotations = []string{
+	v1.MirrorPodAnnotationKey,
+	AuditIgnoreAnnotation,
+	LimitRangerAnnotation,
+}
+
+// slimPod drops the parts of a pod xtop never reads, such as managed fields,
+// environment, probes and volume sources other than claims, so that memory stays bounded
+// on clusters with many pods.
+func slimPod(pod *v1.Pod) {
+	pod.ManagedFields = nil
+	pod.Annotations = keepAnnotations(pod.Annotations)
+
+	for i := range pod.Spec.InitContainers {
+		slimContainer(&pod.Spec.InitContainers[i])
+	}
+	for i := range pod.Spec.Containers {
+		slimContainer(&pod.Spec.Containers[i])
+	}
+	pod.Spec.EphemeralContainers = nil
+
+	volumes := pod.Spec.Volumes[:0]
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			volumes = append(volumes, v1.Volume{
+				Name:         volume.Name,
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: volume.PersistentVolumeClaim},
+			})
+		case volume.Ephemeral != nil:
+			// Generic ephemeral volumes are claims named after the pod and volume;
+			// the claim template is not needed to find them
+			volumes = append(volumes, v1.Volume{
+				Name:         volume.Name,
+				VolumeSource: v1.VolumeSource{Ephemeral: &v1.EphemeralVolumeSource{}},
+			})
+		}
+	}
+	pod.Spec.Volumes = volumes
+}
+
+func slimContainer(container *v1.Container) {
+	container.Command = nil
+	container.Args = nil
+	container.Env = nil
+	container.EnvFrom = nil
+	container.VolumeMounts = nil
+	container.VolumeDevices = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.StartupProbe = nil
+	container.Lifecycle = nil
+	container.SecurityContext = nil
+}
+
+// slimNode drops managed fields, annotations and the image list, which is
+// often the largest part of a node object
+func slimNode(node *v1.Node) {
+	node.ManagedFields = nil
+	node.Annotations = keepAnnotations(node.Annotations)
+	node.Status.Images = nil
+	node.Status.VolumesAttached = nil
+	node.Status.VolumesInUse = nil
+}
+
+// SlimObject applies the same projection to informer objects, for use as a
+// cache.TransformFunc
+func SlimObject(obj interface{}) (interface{}, error) {
+	switch o := obj.(type) {
+	case *v1.Pod:
+		slimPod(o)
+	case *v1.Node:
+		slimNode(o)
+	}
+	return obj, nil
+}
+
+func keepAnnotations(annotations map[string]string) map[string]string {
+	var kept map[string]string
+	for _, key := range keptAnnotations {
+		if value, ok := annotations[key]; ok {
+			if kept == nil {
+				kept = make(map[string]string)
+			}
+			kept[key] = value
+		}
+	}
+	return kept
+}
@@ -0,0 +1,413 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsscheme "k8s.io/metrics/pkg/client/clientset/versioned/scheme"
+)
+
+// Snapshot is the cluster state captured by `xtop snapshot save`
+type Snapshot struct {
+	CapturedAt           metav1.Time                     `json:"capturedAt"`
+	Namespace            string                          `json:"namespace,omitempty"`
+	Nodes                []v1.Node                       `json:"nodes"`
+	Pods                 []v1.Pod                        `json:"pods"`
+	PodMetrics           *metricsv1beta1.PodMetricsList  `json:"podMetrics,omitempty"`
+	NodeMetrics          *metricsv1beta1.NodeMetricsList `json:"nodeMetrics,omitempty"`
+	PodDisruptionBudgets []policyv1.PodDisruptionBudget  `json:"podDisruptionBudgets,omitempty"`
+	LimitRanges          []v1.LimitRange                 `json:"limitRanges,omitempty"`
+	// HorizontalPodAutoscalers and Workloads are only needed by `xtop hpa`
+	HorizontalPodAutoscalers []autoscalingv2.HorizontalPodAutoscaler `json:"horizontalPodAutoscalers,omitempty"`
+	Workloads                []Workload                              `json:"workloads,omitempty"`
+	VerticalPodAutoscalers   []VerticalPodAutoscaler                 `json:"verticalPodAutoscalers,omitempty"`
+	FailedSchedulingEvents   []v1.Event                              `json:"failedSchedulingEvents,omitempty"`
+	PersistentVolumeClaims   []v1.PersistentVolumeClaim              `json:"persistentVolumeClaims,omitempty"`
+	// KubeletConfigs, StatsSummaries and CadvisorStats are keyed by node name. Dumps have none.
+	KubeletConfigs map[string]KubeletConfig `json:"kubeletConfigs,omitempty"`
+	StatsSummaries map[string]StatsSummary  `json:"statsSummaries,omitempty"`
+	CadvisorStats  map[string]CadvisorStats `json:"cadvisorStats,omitempty"`
+	// Skipped holds why optional kinds could not be captured; it is not saved
+	Skipped []error `json:"-"`
+}
+
+// dumpCodecs decodes the core and metrics objects found in `kubectl get -o yaml` dumps
+var dumpCodecs = func() serializer.CodecFactory {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(metricsscheme.AddToScheme(scheme))
+	return serializer.NewCodecFactory(scheme)
+}()
+
+// CaptureSnapshot reads everything xtop needs from a source. Only nodes and
+// pods are required: other kinds may be forbidden or not installed, so their
+// errors are collected in Skipped. Missing metrics, kubelet configs, kubelet
+// stats and cAdvisor metrics are not reported, the snapshot simply has none.
+func CaptureSnapshot(ctx context.Context, src Source) (*Snapshot, error) {
+	var err error
+	snapshot := &Snapshot{
+		CapturedAt: metav1.NewTime(time.Now()),
+		Namespace:  src.DefaultNamespace(),
+	}
+
+	if snapshot.Nodes, err = src.Nodes(ctx); err != nil {
+		return nil, err
+	}
+	if snapshot.Pods, err = src.Pods(ctx, ""); err != nil {
+		return nil, err
+	}
+	optional := func(kind string, err error) {
+		if err != nil {
+			snapshot.Skipped = append(snapshot.Skipped, fmt.Errorf("%s: %w", kind, err))
+		}
+	}
+	snapshot.PodDisruptionBudgets, err = src.PodDisruptionBudgets(ctx)
+	optional("PodDisruptionBudgets", err)
+	snapshot.LimitRanges, err = src.LimitRanges(ctx, "")
+	optional("LimitRanges", err)
+	snapshot.HorizontalPodAutoscalers, err = src.HorizontalPodAutoscalers(ctx, "")
+	optional("HorizontalPodAutoscalers", err)
+	snapshot.Workloads, err = src.Workloads(ctx, "")
+	optional("workloads", err)
+	snapshot.VerticalPodAutoscalers, err = src.VerticalPodAutoscalers(ctx, "")
+	optional("VerticalPodAutoscalers", err)
+	snapshot.FailedSchedulingEvents, err = src.FailedSchedulingEvents(ctx, "")
+	optional("FailedScheduling events", err)
+	snapshot.PersistentVolumeClaims, err = src.PersistentVolumeClaims(ctx, "")
+	optional("PersistentVolumeClaims", err)
+	nodeNames := make([]string, len(snapshot.Nodes))
+	for i, node := range snapshot.Nodes {
+		nodeNames[i] = node.Name
+	}
+	configs, _ := FetchKubeletConfigs(ctx, src, nodeNames)
+	if len(configs) > 0 {
+		snapshot.KubeletConfigs = make(map[string]KubeletConfig, len(configs))
+		for name, config := range configs {
+			snapshot.KubeletConfigs[name] = *config
+		}
+	}
+	summaries, _ := FetchStatsSummaries(ctx, src, nodeNames)
+	if len(summaries) > 0 {
+		snapshot.StatsSummaries = make(map[string]StatsSummary, len(summaries))
+		for name, summary := range summaries {
+			snapshot.StatsSummaries[name] = *summary
+		}
+	}
+	cadvisor, _ := FetchCadvisorStats(ctx, src, nodeNames)
+	if len(cadvisor) > 0 {
+		snapshot.CadvisorStats = make(map[string]CadvisorStats, len(cadvisor))
+		for name, stats := range cadvisor {
+			snapshot.CadvisorStats[name] = *stats
+		}
+	}
+	snapshot.PodMetrics, _ = src.PodMetrics(ctx)
+	snapshot.NodeMetrics, _ = src.NodeMetrics(ctx)
+	return snapshot, nil
+}
+
+// Save writes the snapshot as JSON
+func (s *Snapshot) Save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadSnapshot reads a snapshot written by Save
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("reading snapshot %s: %v", path, err)
+	}
+	return snapshot, nil
+}
+
+// LoadDump builds a snapshot from a directory of `kubectl get -o yaml` (or -o json)
+// output. Files may hold single objects, List objects or several YAML documents.
+// Kinds xtop does not use are ignored.
+func LoadDump(dir string) (*Snapshot, error) {
+	snapshot := &Snapshot{Namespace: "default"}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := snapshot.addDocuments(data); err != nil {
+			return fmt.Errorf("reading %s: %v", path, err)
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(snapshot.CapturedAt.Time) {
+			snapshot.CapturedAt = metav1.NewTime(info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *Snapshot) addDocuments(data []byte) error {
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		var list struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(list.Kind, "List") {
+			if err := s.addObject(raw); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, item := range list.Items {
+			if err := s.addObject(item); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Snapshot) addObject(raw []byte) error {
+	obj, gvk, err := dumpCodecs.UniversalDeserializer().Decode(raw, nil, nil)
+	if err != nil {
+		// VPAs are a CRD outside the scheme
+		if gvk != nil && gvk.Group == vpaResource.Group && gvk.Kind == "VerticalPodAutoscaler" {
+			vpa, err := parseVPA(raw)
+			if err != nil {
+				return err
+			}
+			s.VerticalPodAutoscalers = append(s.VerticalPodAutoscalers, vpa)
+			return nil
+		}
+		if runtime.IsNotRegisteredError(err) || runtime.IsMissingKind(err) {
+			return nil
+		}
+		return err
+	}
+
+	switch o := obj.(type) {
+	case *v1.Node:
+		s.Nodes = append(s.Nodes, *o)
+	case *v1.Pod:
+		s.Pods = append(s.Pods, *o)
+	case *policyv1.PodDisruptionBudget:
+		s.PodDisruptionBudgets = append(s.PodDisruptionBudgets, *o)
+	case *v1.LimitRange:
+		s.LimitRanges = append(s.LimitRanges, *o)
+	case *v1.Event:
+		if o.Reason == FailedSchedulingReason {
+			s.FailedSchedulingEvents = append(s.FailedSchedulingEvents, *o)
+		}
+	case *v1.PersistentVolumeClaim:
+		s.PersistentVolumeClaims = append(s.PersistentVolumeClaims, *o)
+	case *autoscalingv2.HorizontalPodAutoscaler:
+		s.HorizontalPodAutoscalers = append(s.HorizontalPodAutoscalers, *o)
+	case *appsv1.Deployment:
+		s.Workloads = append(s.Workloads, Workload{Kind: "Deployment", Namespace: o.Namespace, Name: o.Name})
+	case *appsv1.StatefulSet:
+		s.Workloads = append(s.Workloads, Workload{Kind: "StatefulSet", Namespace: o.Namespace, Name: o.Name})
+	case *appsv1.ReplicaSet:
+		s.Workloads = append(s.Workloads, Workload{Kind: "ReplicaSet", Namespace: o.Namespace, Name: o.Name})
+	case *metricsv1beta1.PodMetrics:
+		if s.PodMetrics == nil {
+			s.PodMetrics = &metricsv1beta1.PodMetricsList{}
+		}
+		s.PodMetrics.Items = append(s.PodMetrics.Items, *o)
+	case *metricsv1beta1.NodeMetrics:
+		if s.NodeMetrics == nil {
+			s.NodeMetrics = &metricsv1beta1.NodeMetricsList{}
+		}
+		s.NodeMetrics.Items = append(s.NodeMetrics.Items, *o)
+	}
+	return nil
+}
+
+// snapshotSource serves a Snapshot through the Source interface
+type snapshotSource struct {
+	snapshot *Snapshot
+}
+
+// NewSnapshotSource returns a Source that reads from a snapshot instead of a cluster
+func NewSnapshotSource(snapshot *Snapshot) Source {
+	return &snapshotSource{snapshot: snapshot}
+}
+
+func (s *snapshotSource) DefaultNamespace() string {
+	if s.snapshot.Namespace == "" {
+		return "default"
+	}
+	return s.snapshot.Namespace
+}
+
+func (s *snapshotSource) Nodes(ctx context.Context) ([]v1.Node, error) {
+	return s.snapshot.Nodes, nil
+}
+
+func (s *snapshotSource) Pods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	if namespace == "" {
+		return s.snapshot.Pods, nil
+	}
+	var pods []v1.Pod
+	for _, pod := range s.snapshot.Pods {
+		if pod.Namespace == namespace {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func (s *snapshotSource) PodMetrics(ctx context.Context) (*metricsv1beta1.PodMetricsList, error) {
+	if s.snapshot.PodMetrics == nil {
+		return nil, fmt.Errorf("snapshot has no pod metrics")
+	}
+	return s.snapshot.PodMetrics, nil
+}
+
+func (s *snapshotSource) NodeMetrics(ctx context.Context) (*metricsv1beta1.NodeMetricsList, error) {
+	if s.snapshot.NodeMetrics == nil {
+		return nil, fmt.Errorf("snapshot has no node metrics")
+	}
+	return s.snapshot.NodeMetrics, nil
+}
+
+func (s *snapshotSource) PodDisruptionBudgets(ctx context.Context) ([]policyv1.PodDisruptionBudget, error) {
+	return s.snapshot.PodDisruptionBudgets, nil
+}
+
+func (s *snapshotSource) LimitRanges(ctx context.Context, namespace string) ([]v1.LimitRange, error) {
+	if namespace == "" {
+		return s.snapshot.LimitRanges, nil
+	}
+	var limitRanges []v1.LimitRange
+	for _, limitRange := range s.snapshot.LimitRanges {
+		if limitRange.Namespace == namespace {
+			limitRanges = append(limitRanges, limitRange)
+		}
+	}
+	return limitRanges, nil
+}
+
+func (s *snapshotSource) HorizontalPodAutoscalers(ctx context.Context, namespace string) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	var hpas []autoscalingv2.HorizontalPodAutoscaler
+	for _, hpa := range s.snapshot.HorizontalPodAutoscalers {
+		if namespace == "" || hpa.Namespace == namespace {
+			hpas = append(hpas, hpa)
+		}
+	}
+	return hpas, nil
+}
+
+func (s *snapshotSource) Workloads(ctx context.Context, namespace string) ([]Workload, error) {
+	var workloads []Workload
+	for _, workload := range s.snapshot.Workloads {
+		if namespace == "" || workload.Namespace == namespace {
+			workloads = append(workloads, workload)
+		}
+	}
+	return workloads, nil
+}
+
+func (s *snapshotSource) VerticalPodAutoscalers(ctx context.Context, namespace string) ([]VerticalPodAutoscaler, error) {
+	var vpas []VerticalPodAutoscaler
+	for _, vpa := range s.snapshot.VerticalPodAutoscalers {
+		if namespace == "" || vpa.Namespace == namespace {
+			vpas = append(vpas, vpa)
+		}
+	}
+	return vpas, nil
+}
+
+func (s *snapshotSource) FailedSchedulingEvents(ctx context.Context, namespace string) ([]v1.Event, error) {
+	var events []v1.Event
+	for _, event := range s.snapshot.FailedSchedulingEvents {
+		if namespace == "" || event.Namespace == namespace {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *snapshotSource) PersistentVolumeClaims(ctx context.Context, namespace string) ([]v1.PersistentVolumeClaim, error) {
+	var pvcs []v1.PersistentVolumeClaim
+	for _, pvc := range s.snapshot.PersistentVolumeClaims {
+		if namespace == "" || pvc.Namespace == namespace {
+			pvcs = append(pvcs, pvc)
+		}
+	}
+	return pvcs, nil
+}
+
+func (s *snapshotSource) KubeletConfig(ctx context.Context, nodeName string) (*KubeletConfig, error) {
+	config, ok := s.snapshot.KubeletConfigs[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("snapshot has no kubelet config of node %s", nodeName)
+	}
+	return &config, nil
+}
+
+// StatsSummary serves the captured summary, so volume usage is available but
+// rates, which need two samples apart in time, are not
+func (s *snapshotSource) StatsSummary(ctx context.Context, nodeName string) (*StatsSummary, error) {
+	summary, ok := s.snapshot.StatsSummaries[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("snapshot has no kubelet stats of node %s", nodeName)
+	}
+	return &summary, nil
+}
+
+// CadvisorStats serves the captured metrics, so memory is available but
+// throttling, which needs two samples, is not
+func (s *snapshotSource) CadvisorStats(ctx context.Context, nodeName string) (*CadvisorStats, error) {
+	stats, ok := s.snapshot.CadvisorStats[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("snapshot has no cadvisor metrics of node %s", nodeName)
+	}
+	return &stats, nil
+}
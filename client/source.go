@@ -0,0 +1,415 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Source provides the cluster state xtop aggregates, either from a live
+// cluster or from a snapshot on disk.
+type Source interface {
+	// DefaultNamespace is the namespace used when none is given on the command line
+	DefaultNamespace() string
+	Nodes(ctx context.Context) ([]v1.Node, error)
+	// Pods lists pods in a namespace, or in all namespaces when namespace is empty
+	Pods(ctx context.Context, namespace string) ([]v1.Pod, error)
+	PodMetrics(ctx context.Context) (*metricsv1beta1.PodMetricsList, error)
+	NodeMetrics(ctx context.Context) (*metricsv1beta1.NodeMetricsList, error)
+	PodDisruptionBudgets(ctx context.Context) ([]policyv1.PodDisruptionBudget, error)
+	// LimitRanges lists LimitRanges in a namespace, or in all namespaces when namespace is empty
+	LimitRanges(ctx context.Context, namespace string) ([]v1.LimitRange, error)
+	// HorizontalPodAutoscalers lists HPAs in a namespace, or in all namespaces when namespace is empty
+	HorizontalPodAutoscalers(ctx context.Context, namespace string) ([]autoscalingv2.HorizontalPodAutoscaler, error)
+	// Workloads lists Deployments, StatefulSets and ReplicaSets in a namespace, or in all namespaces when namespace is empty
+	Workloads(ctx context.Context, namespace string) ([]Workload, error)
+	// VerticalPodAutoscalers lists VPAs in a namespace, or in all namespaces when
+	// namespace is empty. Clusters without the VPA CRD have none.
+	VerticalPodAutoscalers(ctx context.Context, namespace string) ([]VerticalPodAutoscaler, error)
+	// FailedSchedulingEvents lists the FailedScheduling events in a namespace, or in all namespaces when namespace is empty
+	FailedSchedulingEvents(ctx context.Context, namespace string) ([]v1.Event, error)
+	// PersistentVolumeClaims lists PVCs in a namespace, or in all namespaces when namespace is empty
+	PersistentVolumeClaims(ctx context.Context, namespace string) ([]v1.PersistentVolumeClaim, error)
+	// KubeletConfig reads the running configuration of a node's kubelet
+	KubeletConfig(ctx context.Context, nodeName string) (*KubeletConfig, error)
+	// StatsSummary reads the kubelet summary API of a node
+	StatsSummary(ctx context.Context, nodeName string) (*StatsSummary, error)
+	// CadvisorStats reads the container figures of a node's cAdvisor metrics
+	CadvisorStats(ctx context.Context, nodeName string) (*CadvisorStats, error)
+}
+
+// FailedSchedulingReason is the reason of the events the scheduler records for pods it cannot place
+const FailedSchedulingReason = "FailedScheduling"
+
+// Workload identifies a Deployment, StatefulSet or ReplicaSet
+type Workload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// VerticalPodAutoscaler is the part of an autoscaling.k8s.io VPA xtop uses
+type VerticalPodAutoscaler struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	TargetKind string `json:"targetKind"`
+	TargetName string `json:"targetName"`
+	// UpdateMode is Off for recommendation-only VPAs
+	UpdateMode      string                    `json:"updateMode,omitempty"`
+	Recommendations []ContainerRecommendation `json:"recommendations,omitempty"`
+}
+
+// ContainerRecommendation is the VPA recommendation for one container
+type ContainerRecommendation struct {
+	ContainerName string          `json:"containerName"`
+	LowerBound    v1.ResourceList `json:"lowerBound,omitempty"`
+	Target        v1.ResourceList `json:"target,omitempty"`
+	UpperBound    v1.ResourceList `json:"upperBound,omitempty"`
+}
+
+// KubeletConfig is the part of the kubelet configuration (configz) xtop uses
+type KubeletConfig struct {
+	KubeReserved   map[string]string `json:"kubeReserved,omitempty"`
+	SystemReserved map[string]string `json:"systemReserved,omitempty"`
+	EvictionHard   map[string]string `json:"evictionHard,omitempty"`
+	MaxPods        int32             `json:"maxPods,omitempty"`
+}
+
+// StatsSummary is the part of the kubelet summary API (/stats/summary) xtop uses
+type StatsSummary struct {
+	Node NodeStats  `json:"node"`
+	Pods []PodStats `json:"pods"`
+}
+
+// NodeStats are the node level stats of a summary
+type NodeStats struct {
+	NodeName string        `json:"nodeName"`
+	Network  *NetworkStats `json:"network,omitempty"`
+}
+
+// PodStats are the stats of one pod of a summary
+type PodStats struct {
+	PodRef  PodReference  `json:"podRef"`
+	Network *NetworkStats `json:"network,omitempty"`
+	Volumes []VolumeStats `json:"volume,omitempty"`
+}
+
+// PodReference identifies the pod of PodStats
+type PodReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	UID       string `json:"uid"`
+}
+
+// NetworkStats are the cumulative counters of the default interface, sampled at Time
+type NetworkStats struct {
+	Time    metav1.Time `json:"time"`
+	RxBytes *uint64     `json:"rxBytes,omitempty"`
+	TxBytes *uint64     `json:"txBytes,omitempty"`
+}
+
+// VolumeStats are the filesystem figures of one volume of a pod
+type VolumeStats struct {
+	Name string `json:"name"`
+	// PVCRef is set for volumes backed by a PersistentVolumeClaim
+	PVCRef         *PVCReference `json:"pvcRef,omitempty"`
+	CapacityBytes  *uint64       `json:"capacityBytes,omitempty"`
+	UsedBytes      *uint64       `json:"usedBytes,omitempty"`
+	AvailableBytes *uint64       `json:"availableBytes,omitempty"`
+	Inodes         *uint64       `json:"inodes,omitempty"`
+	InodesFree     *uint64       `json:"inodesFree,omitempty"`
+	InodesUsed     *uint64       `json:"inodesUsed,omitempty"`
+}
+
+// PVCReference identifies the claim of VolumeStats
+type PVCReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// vpaResource is read through the dynamic client, so the VPA module is no dependency
+var vpaResource = schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}
+
+// vpaObject mirrors the fields of an autoscaling.k8s.io/v1 VerticalPodAutoscaler xtop reads
+type vpaObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		TargetRef *struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"targetRef"`
+		UpdatePolicy *struct {
+			UpdateMode string `json:"updateMode"`
+		} `json:"updatePolicy"`
+	} `json:"spec"`
+	Status struct {
+		Recommendation *struct {
+			ContainerRecommendations []ContainerRecommendation `json:"containerRecommendations"`
+		} `json:"recommendation"`
+	} `json:"status"`
+}
+
+// parseVPA decodes a VerticalPodAutoscaler from its JSON form
+func parseVPA(data []byte) (VerticalPodAutoscaler, error) {
+	var obj vpaObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return VerticalPodAutoscaler{}, err
+	}
+	vpa := VerticalPodAutoscaler{Namespace: obj.Metadata.Namespace, Name: obj.Metadata.Name, UpdateMode: "Auto"}
+	if obj.Spec.TargetRef != nil {
+		vpa.TargetKind, vpa.TargetName = obj.Spec.TargetRef.Kind, obj.Spec.TargetRef.Name
+	}
+	if obj.Spec.UpdatePolicy != nil && obj.Spec.UpdatePolicy.UpdateMode != "" {
+		vpa.UpdateMode = obj.Spec.UpdatePolicy.UpdateMode
+	}
+	if obj.Status.Recommendation != nil {
+		vpa.Recommendations = obj.Status.Recommendation.ContainerRecommendations
+	}
+	return vpa, nil
+}
+
+// liveSource reads from the API server and metrics-server
+type liveSource struct {
+	clientset  kubernetes.Interface
+	metrics    metrics.Interface
+	dynamic    dynamic.Interface
+	kubeConfig clientcmd.ClientConfig
+}
+
+// NewLiveSource returns a Source backed by the initialized Clientset and Config
+func NewLiveSource() (Source, error) {
+	metricsClient, err := metrics.NewForConfig(Config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(Config)
+	if err != nil {
+		return nil, err
+	}
+	return &liveSource{clientset: Clientset, metrics: metricsClient, dynamic: dynamicClient, kubeConfig: kubeClientConfig("")}, nil
+}
+
+// NewContextSource returns a Source for a named kubeconfig context
+func NewContextSource(contextName string) (Source, error) {
+	kubeConfig := kubeClientConfig(contextName)
+	config, err := kubeConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(protobufConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	metricsClient, err := metrics.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &liveSource{clientset: clientset, metrics: metricsClient, dynamic: dynamicClient, kubeConfig: kubeConfig}, nil
+}
+
+func (s *liveSource) DefaultNamespace() string {
+	namespace, _, err := s.kubeConfig.Namespace()
+	if err != nil || namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func (s *liveSource) Nodes(ctx context.Context) ([]v1.Node, error) {
+	var nodes []v1.Node
+	opts := metav1.ListOptions{Limit: listPageSize}
+	for {
+		page, err := s.clientset.CoreV1().Nodes().List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Items {
+			if !FullObjects {
+				slimNode(&page.Items[i])
+			}
+		}
+		nodes = append(nodes, page.Items...)
+		if page.Continue == "" {
+			return nodes, nil
+		}
+		opts.Continue = page.Continue
+	}
+}
+
+func (s *liveSource) Pods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	var pods []v1.Pod
+	opts := metav1.ListOptions{Limit: listPageSize}
+	for {
+		page, err := s.clientset.CoreV1().Pods(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Items {
+			if !FullObjects {
+				slimPod(&page.Items[i])
+			}
+		}
+		pods = append(pods, page.Items...)
+		if page.Continue == "" {
+			return pods, nil
+		}
+		opts.Continue = page.Continue
+	}
+}
+
+func (s *liveSource) PodMetrics(ctx context.Context) (*metricsv1beta1.PodMetricsList, error) {
+	return s.metrics.MetricsV1beta1().PodMetricses("").List(ctx, metav1.ListOptions{})
+}
+
+func (s *liveSource) NodeMetrics(ctx context.Context) (*metricsv1beta1.NodeMetricsList, error) {
+	return s.metrics.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
+}
+
+func (s *liveSource) PodDisruptionBudgets(ctx context.Context) ([]policyv1.PodDisruptionBudget, error) {
+	pdbs, err := s.clientset.PolicyV1().PodDisruptionBudgets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return pdbs.Items, nil
+}
+
+func (s *liveSource) LimitRanges(ctx context.Context, namespace string) ([]v1.LimitRange, error) {
+	limitRanges, err := s.clientset.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return limitRanges.Items, nil
+}
+
+func (s *liveSource) HorizontalPodAutoscalers(ctx context.Context, namespace string) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpas, err := s.clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return hpas.Items, nil
+}
+
+func (s *liveSource) Workloads(ctx context.Context, namespace string) ([]Workload, error) {
+	var workloads []Workload
+	deployments, err := s.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		workloads = append(workloads, Workload{Kind: "Deployment", Namespace: d.Namespace, Name: d.Name})
+	}
+	statefulSets, err := s.clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, sts := range statefulSets.Items {
+		workloads = append(workloads, Workload{Kind: "StatefulSet", Namespace: sts.Namespace, Name: sts.Name})
+	}
+	replicaSets, err := s.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range replicaSets.Items {
+		workloads = append(workloads, Workload{Kind: "ReplicaSet", Namespace: rs.Namespace, Name: rs.Name})
+	}
+	return workloads, nil
+}
+
+func (s *liveSource) FailedSchedulingEvents(ctx context.Context, namespace string) ([]v1.Event, error) {
+	events, err := s.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: "reason=" + FailedSchedulingReason})
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (s *liveSource) PersistentVolumeClaims(ctx context.Context, namespace string) ([]v1.PersistentVolumeClaim, error) {
+	pvcs, err := s.clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return pvcs.Items, nil
+}
+
+func (s *liveSource) VerticalPodAutoscalers(ctx context.Context, namespace string) ([]VerticalPodAutoscaler, error) {
+	list, err := s.dynamic.Resource(vpaResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vpas := make([]VerticalPodAutoscaler, 0, len(list.Items))
+	for _, item := range list.Items {
+		data, err := item.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		vpa, err := parseVPA(data)
+		if err != nil {
+			return nil, err
+		}
+		vpas = append(vpas, vpa)
+	}
+	return vpas, nil
+}
+
+// KubeletConfig reads /configz of the kubelet through the API server node proxy
+func (s *liveSource) KubeletConfig(ctx context.Context, nodeName string) (*KubeletConfig, error) {
+	data, err := s.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", nodeName, "proxy", "configz").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var configz struct {
+		KubeletConfig *KubeletConfig `json:"kubeletconfig"`
+	}
+	if err := json.Unmarshal(data, &configz); err != nil {
+		return nil, fmt.Errorf("decoding configz of node %s: %w", nodeName, err)
+	}
+	if configz.KubeletConfig == nil {
+		return nil, fmt.Errorf("configz of node %s has no kubeletconfig", nodeName)
+	}
+	return configz.KubeletConfig, nil
+}
+
+// StatsSummary reads /stats/summary of the kubelet through the API server node proxy
+func (s *liveSource) StatsSummary(ctx context.Context, nodeName string) (*StatsSummary, error) {
+	data, err := s.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", nodeName, "proxy", "stats", "summary").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary := &StatsSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return nil, fmt.Errorf("decoding stats summary of node %s: %w", nodeName, err)
+	}
+	return summary, nil
+}
+
+// CadvisorStats reads /metrics/cadvisor of the kubelet through the API server node proxy
+func (s *liveSource) CadvisorStats(ctx context.Context, nodeName string) (*CadvisorStats, error) {
+	data, err := s.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", nodeName, "proxy", "metrics", "cadvisor").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := parseCadvisor(data)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", nodeName, err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,356 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	auditNamespace       string
+	auditRatioFactor     float64
+	auditUsageFactor     float64
+	auditLatencySelector string
+	auditProdNamespaces  []string
+	auditMinSeverity     string
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Report resource anti-patterns of pods grouped by namespace and owner",
+	Long: `Scan running and pending pods for resource anti-patterns and report them per
+namespace and owning workload. Pods of the same workload are reported once.
+
+  missing-requests        container without a CPU or memory request (warning)
+  missing-limits          container without a memory limit (warning) or a CPU
+                          limit (info)
+  stateful-memory         StatefulSet container whose memory limit differs from
+                          its request (warning)
+  latency-cpu-limit       CPU limit on a pod matching --latency-selector, which
+                          gets throttled under load (warning)
+  limit-ratio             limit above --ratio-factor times the request (info)
+  overprovisioned         request above --usage-factor times the metrics-server
+                          usage (info)
+  besteffort-production   BestEffort pod in a namespace matching
+                          --production-namespaces (critical)
+
+Findings are suppressed per pod with the xtop/audit-ignore annotation, set to
+a comma separated list of rules or to "all".`,
+	Example: `  xtop audit
+  xtop audit -n shop --min-severity warning
+  kubectl annotate pod web-0 xtop/audit-ignore=limit-ratio,overprovisioned`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAuditCommand()
+	},
+}
+
+type auditSeverity int
+
+const (
+	severityInfo auditSeverity = iota
+	severityWarning
+	severityCritical
+)
+
+var auditSeverities = map[string]auditSeverity{
+	"info":     severityInfo,
+	"warning":  severityWarning,
+	"critical": severityCritical,
+}
+
+func (s auditSeverity) String() string {
+	switch s {
+	case severityCritical:
+		return "critical"
+	case severityWarning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// auditFinding is one rule violated by a container, or by the pod when container is empty
+type auditFinding struct {
+	namespace string
+	owner     string
+	container string
+	rule      string
+	severity  auditSeverity
+	detail    string
+	// pods counts the pods of the owner with the same finding
+	pods int
+}
+
+// auditor holds what the rules need besides the pod itself
+type auditor struct {
+	latency labels.Selector
+	// usage is metrics-server usage keyed by namespace/pod/container, nil without metrics
+	usage map[string]v1.ResourceList
+}
+
+func runAuditCommand() error {
+	minSeverity, ok := auditSeverities[auditMinSeverity]
+	if !ok {
+		return fmt.Errorf("invalid --min-severity %q: must be info, warning or critical", auditMinSeverity)
+	}
+	latency, err := labels.Parse(auditLatencySelector)
+	if err != nil {
+		return fmt.Errorf("invalid --latency-selector: %w", err)
+	}
+	for _, pattern := range auditProdNamespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid --production-namespaces pattern %q: %w", pattern, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{
+		Pods:       true,
+		Namespace:  auditNamespace,
+		PodMetrics: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	a := &auditor{latency: latency}
+	if state.MetricsErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Metrics not available, skipping overprovisioned: %v\n", state.MetricsErr)
+	} else {
+		a.usage = make(map[string]v1.ResourceList)
+		for _, podMetric := range state.PodMetrics.Items {
+			for _, container := range podMetric.Containers {
+				a.usage[podMetric.Namespace+"/"+podMetric.Name+"/"+container.Name] = container.Usage
+			}
+		}
+	}
+
+	// Aggregate the findings of pods with the same owner, keeping the detail of the first pod by name
+	pods := make([]*v1.Pod, 0, len(state.Pods))
+	for i := range state.Pods {
+		if phase := state.Pods[i].Status.Phase; phase != v1.PodSucceeded && phase != v1.PodFailed {
+			pods = append(pods, &state.Pods[i])
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	aggregated := make(map[string]*auditFinding)
+	suppressed := 0
+	for _, pod := range pods {
+		ignored := auditIgnoredRules(pod)
+		for _, finding := range a.auditPod(pod) {
+			if ignored["all"] || ignored[finding.rule] {
+				suppressed++
+				continue
+			}
+			key := strings.Join([]string{finding.namespace, finding.owner, finding.container, finding.rule}, "/")
+			if existing, ok := aggregated[key]; ok {
+				existing.pods++
+				continue
+			}
+			finding.pods = 1
+			aggregated[key] = &finding
+		}
+	}
+
+	var findings []auditFinding
+	counts := make(map[auditSeverity]int)
+	for _, finding := range aggregated {
+		if finding.severity < minSeverity {
+			continue
+		}
+		findings = append(findings, *finding)
+		counts[finding.severity]++
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		fi, fj := findings[i], findings[j]
+		if fi.namespace != fj.namespace {
+			return fi.namespace < fj.namespace
+		}
+		if fi.owner != fj.owner {
+			return fi.owner < fj.owner
+		}
+		if fi.severity != fj.severity {
+			return fi.severity > fj.severity
+		}
+		if fi.rule != fj.rule {
+			return fi.rule < fj.rule
+		}
+		return fi.container < fj.container
+	})
+
+	printAuditFindings(findings)
+	fmt.Printf("\nFindings: %d (%d critical, %d warning, %d info)", len(findings), counts[severityCritical], counts[severityWarning], counts[severityInfo])
+	if suppressed > 0 {
+		fmt.Printf(", %d suppressed by %s", suppressed, client.AuditIgnoreAnnotation)
+	}
+	fmt.Println()
+	return nil
+}
+
+// auditPod applies every rule to a pod, one finding per violating container
+func (a *auditor) auditPod(pod *v1.Pod) []auditFinding {
+	ref := podWorkload(pod)
+	owner := ref.kind + "/" + ref.name
+	var findings []auditFinding
+	add := func(container, rule string, severity auditSeverity, format string, args ...interface{}) {
+		findings = append(findings, auditFinding{
+			namespace: pod.Namespace,
+			owner:     owner,
+			container: container,
+			rule:      rule,
+			severity:  severity,
+			detail:    fmt.Sprintf(format, args...),
+		})
+	}
+
+	if isBestEffort(pod) && isProductionNamespace(pod.Namespace) {
+		add("", "besteffort-production", severityCritical, "no container sets requests or limits")
+	}
+
+	latencySensitive := !a.latency.Empty() && a.latency.Matches(labels.Set(pod.Labels))
+	for _, container := range pod.Spec.Containers {
+		requests, limits := container.Resources.Requests, container.Resources.Limits
+
+		if missing := missingResources(requests); len(missing) > 0 {
+			add(container.Name, "missing-requests", severityWarning, "no %s request", strings.Join(missing, ", "))
+		}
+		if missing := missingResources(limits); len(missing) > 0 {
+			severity := severityInfo
+			if _, ok := limits[v1.ResourceMemory]; !ok {
+				severity = severityWarning
+			}
+			add(container.Name, "missing-limits", severity, "no %s limit", strings.Join(missing, ", "))
+		}
+
+		memRequest, hasMemRequest := requests[v1.ResourceMemory]
+		memLimit, hasMemLimit := limits[v1.ResourceMemory]
+		if ref.kind == "StatefulSet" && hasMemRequest && hasMemLimit && memRequest.Cmp(memLimit) != 0 {
+			add(container.Name, "stateful-memory", severityWarning, "memory request %s, limit %s", formatQuantity(memRequest), formatQuantity(memLimit))
+		}
+
+		if cpuLimit, ok := limits[v1.ResourceCPU]; ok && latencySensitive {
+			add(container.Name, "latency-cpu-limit", severityWarning, "cpu limit %s", formatQuantity(cpuLimit))
+		}
+
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			request, hasRequest := requests[name]
+			limit, hasLimit := limits[name]
+			if hasRequest && hasLimit && !request.IsZero() && quantityRatio(limit, request) > auditRatioFactor {
+				add(container.Name, "limit-ratio", severityInfo, "%s limit %s is %.1fx the request %s", name, formatQuantity(limit), quantityRatio(limit, request), formatQuantity(request))
+			}
+		}
+
+		usage, ok := a.usage[pod.Namespace+"/"+pod.Name+"/"+container.Name]
+		if !ok || pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			request, hasRequest := requests[name]
+			used := usage[name]
+			if hasRequest && !request.IsZero() && !used.IsZero() && quantityRatio(request, used) > auditUsageFactor {
+				add(container.Name, "overprovisioned", severityInfo, "%s request %s is %.1fx the usage %s", name, formatQuantity(request), quantityRatio(request, used), formatQuantity(used))
+			}
+		}
+	}
+	return findings
+}
+
+// auditIgnoredRules reads the rules suppressed by the pod's annotation
+func auditIgnoredRules(pod *v1.Pod) map[string]bool {
+	ignored := make(map[string]bool)
+	for _, rule := range strings.Split(pod.Annotations[client.AuditIgnoreAnnotation], ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			ignored[rule] = true
+		}
+	}
+	return ignored
+}
+
+// isBestEffort uses the QoS class the API server computed, or derives it when missing
+func isBestEffort(pod *v1.Pod) bool {
+	if pod.Status.QOSClass != "" {
+		return pod.Status.QOSClass == v1.PodQOSBestEffort
+	}
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			if len(container.Resources.Requests) > 0 || len(container.Resources.Limits) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isProductionNamespace(namespace string) bool {
+	for _, pattern := range auditProdNamespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// missingResources lists which of cpu and memory a resource list does not set
+func missingResources(list v1.ResourceList) []string {
+	var missing []string
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		if _, ok := list[name]; !ok {
+			missing = append(missing, string(name))
+		}
+	}
+	return missing
+}
+
+func quantityRatio(a, b resource.Quantity) float64 {
+	return float64(a.MilliValue()) / float64(b.MilliValue())
+}
+
+func printAuditFindings(findings []auditFinding) {
+	// No TabIndent, which would turn the blank leading cells of a group into tabs
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tOWNER\tSEVERITY\tRULE\tCONTAINER\tPODS\tDETAIL")
+
+	// Repeated namespaces and owners are left blank so groups stand out
+	var lastNamespace, lastOwner string
+	for _, f := range findings {
+		namespace, owner := f.namespace, f.owner
+		if namespace == lastNamespace {
+			namespace = ""
+			if owner == lastOwner {
+				owner = ""
+			}
+		}
+		lastNamespace, lastOwner = f.namespace, f.owner
+
+		container := f.container
+		if container == "" {
+			container = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", namespace, owner, f.severity, f.rule, container, f.pods, f.detail)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().StringVarP(&auditNamespace, "namespace", "n", "", "Only audit pods in this namespace (default all namespaces)")
+	auditCmd.Flags().Float64Var(&auditRatioFactor, "ratio-factor", 4, "Report limits above this multiple of the request")
+	auditCmd.Flags().Float64Var(&auditUsageFactor, "usage-factor", 4, "Report requests above this multiple of the current usage")
+	auditCmd.Flags().StringVar(&auditLatencySelector, "latency-selector", "xtop/latency-sensitive=true", "Label selector of latency-sensitive pods, which should not have CPU limits")
+	auditCmd.Flags().StringSliceVar(&auditProdNamespaces, "production-namespaces", []string{"prod", "production", "prod-*", "*-prod", "production-*", "*-production"}, "Namespace patterns in which BestEffort pods are critical")
+	auditCmd.Flags().StringVar(&auditMinSeverity, "min-severity", "info", "Only report findings of at least this severity: info, warning, critical")
+}
@@ -0,0 +1,223 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+)
+
+// throttleThreshold is the throttled share of CFS periods from which THROTTLED is painted red
+var throttleThreshold float64
+
+// throttleColumnIDs need two cAdvisor samples, memoryColumnIDs one
+var (
+	throttleColumnIDs = []string{"throttled", "throttled-containers"}
+	memoryColumnIDs   = []string{"mem-rss", "mem-cache", "mem-working-set"}
+)
+
+// throttling counts the CFS periods of a pod between two samples
+type throttling struct {
+	periods   float64
+	throttled float64
+	// containers are the throttled shares of the containers with a CPU limit,
+	// most throttled first
+	containers []containerThrottling
+}
+
+// containerThrottling is the throttled share of CFS periods of one container
+type containerThrottling struct {
+	name    string
+	percent float64
+}
+
+// memoryBreakdown is the cAdvisor memory of a pod in bytes, summed over its containers
+type memoryBreakdown struct {
+	rss        float64
+	cache      float64
+	workingSet float64
+}
+
+var podCadvisorColumns = []podColumn{
+	{
+		id:     "throttled",
+		header: "THROTTLED",
+		getter: func(pod podInfo) string {
+			switch {
+			case pod.throttling == nil:
+				return plainCell("<unknown>")
+			case pod.throttling.periods == 0:
+				// No CPU limit, or no CFS period elapsed
+				return plainCell("-")
+			}
+			percent := pod.throttling.percent()
+			if percent >= throttleThreshold {
+				return paint(colorRed, fmt.Sprintf("%.0f%%", percent))
+			}
+			return plainCell(fmt.Sprintf("%.0f%%", percent))
+		},
+		colored: true,
+	},
+	{
+		id:     "throttled-containers",
+		header: "THROTTLED BY CONTAINER",
+		getter: func(pod podInfo) string {
+			switch {
+			case pod.throttling == nil:
+				return "<unknown>"
+			case len(pod.throttling.containers) == 0:
+				return "-"
+			}
+			parts := make([]string, len(pod.throttling.containers))
+			for i, c := range pod.throttling.containers {
+				parts[i] = fmt.Sprintf("%s=%.0f%%", c.name, c.percent)
+			}
+			return strings.Join(parts, ",")
+		},
+	},
+	{
+		id:     "mem-rss",
+		header: "MEM RSS",
+		getter: func(pod podInfo) string {
+			return formatMemory(pod.memory, func(m *memoryBreakdown) float64 { return m.rss })
+		},
+	},
+	{
+		id:     "mem-cache",
+		header: "MEM CACHE",
+		getter: func(pod podInfo) string {
+			return formatMemory(pod.memory, func(m *memoryBreakdown) float64 { return m.cache })
+		},
+	},
+	{
+		id:     "mem-working-set",
+		header: "MEM WORKING SET",
+		getter: func(pod podInfo) string {
+			return formatMemory(pod.memory, func(m *memoryBreakdown) float64 { return m.workingSet })
+		},
+	},
+}
+
+// percent is the share of CFS periods in which the pod was throttled
+func (t *throttling) percent() float64 {
+	if t.periods == 0 {
+		return 0
+	}
+	return t.throttled / t.periods * 100
+}
+
+// throttledValue is the sort value of a pod, -1 when unknown so unknown pods sort first
+func (p podInfo) throttledValue() float64 {
+	if p.throttling == nil {
+		return -1
+	}
+	return p.throttling.percent()
+}
+
+// sampleCadvisor reads the cAdvisor metrics of the nodes, warning about the ones that fail
+func sampleCadvisor(ctx context.Context, cluster string, src client.Source, nodeNames []string) map[string]*client.CadvisorStats {
+	stats, err := client.FetchCadvisorStats(ctx, src, nodeNames)
+	if err != nil {
+		kubeletWarning(cluster, err)
+	}
+	return stats
+}
+
+// applyCadvisor attaches the memory of the last sample to the pods and, given
+// an earlier sample, the throttling in between. Containers whose counters went
+// back, because they restarted, are left out of the throttling.
+func applyCadvisor(podsList podInfoList, first, last map[string]*client.CadvisorStats) {
+	type containerKey struct{ namespace, pod, container string }
+	var previous map[containerKey]client.ContainerStats
+	if first != nil {
+		previous = make(map[containerKey]client.ContainerStats)
+		for _, stats := range first {
+			for _, c := range stats.Containers {
+				previous[containerKey{c.Namespace, c.Pod, c.Container}] = c
+			}
+		}
+	}
+
+	memory := make(map[string]*memoryBreakdown)
+	throttled := make(map[string]*throttling)
+	for _, stats := range last {
+		for _, c := range stats.Containers {
+			key := c.Namespace + "/" + c.Pod
+			if memory[key] == nil {
+				memory[key] = &memoryBreakdown{}
+			}
+			memory[key].rss += c.RSS
+			memory[key].cache += c.Cache
+			memory[key].workingSet += c.WorkingSet
+
+			before, ok := previous[containerKey{c.Namespace, c.Pod, c.Container}]
+			if !ok {
+				continue
+			}
+			periods := c.CFSPeriods - before.CFSPeriods
+			throttledPeriods := c.CFSThrottledPeriods - before.CFSThrottledPeriods
+			if periods < 0 || throttledPeriods < 0 {
+				continue
+			}
+			if throttled[key] == nil {
+				throttled[key] = &throttling{}
+			}
+			t := throttled[key]
+			t.periods += periods
+			t.throttled += throttledPeriods
+			if periods > 0 {
+				t.containers = append(t.containers, containerThrottling{name: c.Container, percent: throttledPeriods / periods * 100})
+			}
+		}
+	}
+	for _, t := range throttled {
+		sort.Slice(t.containers, func(i, j int) bool {
+			if t.containers[i].percent != t.containers[j].percent {
+				return t.containers[i].percent > t.containers[j].percent
+			}
+			return t.containers[i].name < t.containers[j].name
+		})
+	}
+
+	for i := range podsList {
+		key := podsList[i].namespace + "/" + podsList[i].name
+		podsList[i].memory = memory[key]
+		podsList[i].throttling = throttled[key]
+	}
+}
+
+// addCadvisor adds the memory and CFS periods of a pod to the total row
+func addCadvisor(total *podInfo, pod podInfo) {
+	if pod.memory != nil {
+		if total.memory == nil {
+			total.memory = &memoryBreakdown{}
+		}
+		total.memory.rss += pod.memory.rss
+		total.memory.cache += pod.memory.cache
+		total.memory.workingSet += pod.memory.workingSet
+	}
+	if pod.throttling != nil {
+		if total.throttling == nil {
+			total.throttling = &throttling{}
+		}
+		total.throttling.periods += pod.throttling.periods
+		total.throttling.throttled += pod.throttling.throttled
+	}
+}
+
+func formatMemory(memory *memoryBreakdown, value func(*memoryBreakdown) float64) string {
+	if memory == nil {
+		return "<unknown>"
+	}
+	return formatBytes(value(memory))
+}
+
+func addThrottleThresholdFlag(cmd *cobra.Command) {
+	cmd.Flags().Float64Var(&throttleThreshold, "throttle-threshold", 25, "Paint THROTTLED red from this percentage of throttled CFS periods, a sign of a CPU limit below the real need")
+}
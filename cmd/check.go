@@ -0,0 +1,337 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// checkFailedExitCode is returned when an error severity check is violated,
+// so pipelines can tell violations apart from xtop itself failing (exit 1)
+const checkFailedExitCode = 2
+
+var (
+	failIf     []string
+	checksFile string
+	junitFile  string
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Evaluate threshold rules and exit non-zero when one is violated",
+	Long: `Evaluate threshold rules against the cluster and print the offending rows.
+
+A rule is scope.metric<op>value, where op is one of > >= < <= == !=:
+
+  node.<metric>       cpu-req, cpu-limit, cpu-usage, mem-req, mem-limit,
+                      mem-usage as a quantity or a % of node capacity; pods
+  namespace.<metric>  the same resources as a quantity or a % of cluster
+                      allocatable; pods
+  cluster.<metric>    the same resources summed over the cluster; nodes,
+                      nodes-not-ready, nodes-cordoned
+  pods.<metric>       total, pending, failed, crashlooping
+
+Rules are given with --fail-if or as named checks in a --checks-file:
+
+  checks:
+    - name: node-cpu-pressure
+      rule: node.cpu-req>90%
+    - name: pending-pods
+      rule: pods.pending>0
+      severity: warning
+
+xtop exits with 2 when a check of severity error (the default) is violated.
+Warnings are reported but do not change the exit code.`,
+	Example: `  xtop check --fail-if 'node.cpu-req>90%' --fail-if 'pods.pending>0'
+  xtop check --checks-file checks.yaml --junit report.xml`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCheckCommand(cmd)
+	},
+}
+
+// checksConfig is the format of --checks-file
+type checksConfig struct {
+	Checks []checkRule `json:"checks"`
+}
+
+type checkRule struct {
+	Name     string `json:"name"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity,omitempty"`
+	expr     *ruleExpr
+}
+
+type checkResult struct {
+	check checkRule
+	// rows are the offending rows only
+	rows []checkRow
+	err  error
+}
+
+func (r checkResult) failed() bool {
+	return r.err != nil || len(r.rows) > 0
+}
+
+// exitCodeError makes Execute exit with a specific code
+type exitCodeError struct {
+	code int
+	msg  string
+}
+
+func (e *exitCodeError) Error() string {
+	return e.msg
+}
+
+func runCheckCommand(cmd *cobra.Command) error {
+	checks, err := loadChecks()
+	if err != nil {
+		return err
+	}
+	// From here on errors are about the cluster, not the command line
+	cmd.SilenceUsage = true
+
+	state, err := loadCheckState()
+	if err != nil {
+		return err
+	}
+
+	results := make([]checkResult, 0, len(checks))
+	for _, check := range checks {
+		result := checkResult{check: check}
+		rows, err := check.expr.evaluate(state)
+		if err != nil {
+			result.err = err
+		}
+		for _, row := range rows {
+			if row.violates {
+				result.rows = append(result.rows, row)
+			}
+		}
+		results = append(results, result)
+	}
+
+	printCheckResults(results)
+
+	if junitFile != "" {
+		if err := writeJUnitReport(junitFile, results); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.failed() && result.check.Severity == "error" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &exitCodeError{code: checkFailedExitCode, msg: fmt.Sprintf("%d of %d checks failed", failed, len(results))}
+	}
+	return nil
+}
+
+// loadChecks combines --checks-file and --fail-if rules
+func loadChecks() ([]checkRule, error) {
+	var checks []checkRule
+	if checksFile != "" {
+		data, err := os.ReadFile(checksFile)
+		if err != nil {
+			return nil, err
+		}
+		var config checksConfig
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid checks file %s: %v", checksFile, err)
+		}
+		checks = append(checks, config.Checks...)
+	}
+	for _, rule := range failIf {
+		checks = append(checks, checkRule{Rule: rule})
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("no checks given: use --fail-if or --checks-file")
+	}
+
+	for i := range checks {
+		check := &checks[i]
+		if check.Rule == "" {
+			return nil, fmt.Errorf("check %q has no rule", check.Name)
+		}
+		if check.Name == "" {
+			check.Name = check.Rule
+		}
+		switch check.Severity {
+		case "":
+			check.Severity = "error"
+		case "error", "warning":
+		default:
+			return nil, fmt.Errorf("check %q has invalid severity %q: must be error or warning", check.Name, check.Severity)
+		}
+		expr, err := parseRule(check.Rule)
+		if err != nil {
+			return nil, err
+		}
+		check.expr = expr
+	}
+	return checks, nil
+}
+
+// loadCheckState aggregates the cluster the same way the nodes, namespaces and cluster views do
+func loadCheckState() (*checkState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	fetched, err := client.FetchState(ctx, dataSource, client.FetchRequest{Nodes: true, Pods: true, PodMetrics: true})
+	if err != nil {
+		return nil, err
+	}
+
+	podsList := getPodInfoList(fetched.Pods, fetched.PodMetrics)
+	summary := getClusterSummary(fetched.Nodes, fetched.Pods, podsList)
+	state := &checkState{
+		nodes:       summary.nodesList,
+		nodePods:    make(map[string]int),
+		namespaces:  summary.namespaces,
+		pods:        podsList,
+		summary:     summary,
+		metricsErr:  fetched.MetricsErr,
+		allocatable: summary.allocatable,
+	}
+
+	// Node usage is the sum of the usage of its pods
+	nodesByName := make(map[string]nodeInfo, len(state.nodes))
+	for _, node := range state.nodes {
+		node.resources["cpuUsage"] = resource.NewQuantity(0, resource.DecimalSI)
+		node.resources["memUsage"] = resource.NewQuantity(0, resource.BinarySI)
+		nodesByName[node.name] = node
+	}
+	for _, pod := range podsList {
+		node, ok := nodesByName[pod.nodeName]
+		if !ok || pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+		state.nodePods[pod.nodeName]++
+		node.resources["cpuUsage"].Add(*pod.cpuUsage)
+		node.resources["memUsage"].Add(*pod.memUsage)
+	}
+	return state, nil
+}
+
+func printCheckResults(results []checkResult) {
+	passed, failed, warned := 0, 0, 0
+	for _, result := range results {
+		status := paint(colorGreen, "PASS")
+		switch {
+		case !result.failed():
+			passed++
+		case result.check.Severity == "warning":
+			status = paint(colorYellow, "WARN")
+			warned++
+		default:
+			status = paint(colorRed, "FAIL")
+			failed++
+		}
+
+		if result.check.Name == result.check.Rule {
+			fmt.Printf("%s  %s\n", status, result.check.Rule)
+		} else {
+			fmt.Printf("%s  %s (%s)\n", status, result.check.Name, result.check.Rule)
+		}
+		if result.err != nil {
+			fmt.Printf("      error: %v\n", result.err)
+		}
+		if len(result.rows) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			for _, row := range result.rows {
+				fmt.Fprintf(w, "      %s\t%s\n", row.subject, row.display)
+			}
+			w.Flush()
+		}
+	}
+	fmt.Printf("\n%d checks: %d passed, %d failed, %d warnings\n", len(results), passed, failed, warned)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes one test case per check. Violated warnings pass and
+// carry the offending rows in system-out.
+func writeJUnitReport(path string, results []checkResult) error {
+	suite := junitTestSuite{Name: "xtop", Tests: len(results), Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	for _, result := range results {
+		testCase := junitTestCase{Name: result.check.Name, ClassName: "xtop." + result.check.expr.scope}
+
+		var rows []string
+		for _, row := range result.rows {
+			rows = append(rows, row.subject+" "+row.display)
+		}
+		details := strings.Join(rows, "\n")
+
+		switch {
+		case !result.failed():
+		case result.check.Severity == "warning" && result.err != nil:
+			testCase.SystemOut = fmt.Sprintf("warning: %s could not be evaluated: %v", result.check.Rule, result.err)
+		case result.check.Severity == "warning":
+			testCase.SystemOut = fmt.Sprintf("warning: %s violated\n%s", result.check.Rule, details)
+		case result.err != nil:
+			testCase.Error = &junitProblem{Message: result.err.Error(), Type: "error"}
+			suite.Errors++
+		default:
+			testCase.Failure = &junitProblem{
+				Message: fmt.Sprintf("%s violated by %d rows", result.check.Rule, len(result.rows)),
+				Type:    result.check.Severity,
+				Text:    details,
+			}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0o644)
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringArrayVar(&failIf, "fail-if", nil, "Rule that fails the check when true, e.g. 'node.cpu-req>90%' (repeatable)")
+	checkCmd.Flags().StringVar(&checksFile, "checks-file", "", "YAML file with named checks and severities")
+	checkCmd.Flags().StringVar(&junitFile, "junit", "", "Write a JUnit XML report to this file")
+}
@@ -0,0 +1,230 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ruleExpr is a parsed rule such as node.cpu-req>90%
+type ruleExpr struct {
+	scope     string
+	metric    string
+	op        string
+	threshold float64
+	percent   bool
+}
+
+// checkRow is one node, namespace, pod or cluster a rule looked at
+type checkRow struct {
+	subject  string
+	display  string
+	violates bool
+}
+
+// checkState is everything rules are evaluated against
+type checkState struct {
+	nodes       nodeInfoList
+	nodePods    map[string]int
+	namespaces  namespaceInfoList
+	pods        podInfoList
+	summary     clusterSummary
+	metricsErr  error
+	allocatable map[string]*resource.Quantity
+}
+
+var ruleRegexp = regexp.MustCompile(`^\s*([a-z]+)\.([a-z-]+)\s*(>=|<=|==|!=|>|<)\s*([0-9.]+[A-Za-z]*)(%?)\s*$`)
+
+// resourceMetrics are the metrics of node, namespace and cluster rules that
+// can be given as a percentage of capacity
+var resourceMetrics = map[string]string{
+	"cpu-req":   "cpuReq",
+	"cpu-limit": "cpuLimit",
+	"cpu-usage": "cpuUsage",
+	"mem-req":   "memReq",
+	"mem-limit": "memLimit",
+	"mem-usage": "memUsage",
+}
+
+// countMetrics are the plain counts each scope supports
+var countMetrics = map[string][]string{
+	"node":      {"pods"},
+	"namespace": {"pods"},
+	"cluster":   {"nodes", "nodes-not-ready", "nodes-cordoned"},
+	"pods":      {"total", "pending", "failed", "crashlooping"},
+}
+
+// parseRule parses scope.metric<op>threshold with an optional % suffix
+func parseRule(rule string) (*ruleExpr, error) {
+	match := ruleRegexp.FindStringSubmatch(rule)
+	if match == nil {
+		return nil, fmt.Errorf("invalid rule %q: expected scope.metric<op>value, e.g. node.cpu-req>90%%", rule)
+	}
+	expr := &ruleExpr{scope: match[1], metric: match[2], op: match[3], percent: match[5] == "%"}
+
+	counts, ok := countMetrics[expr.scope]
+	if !ok {
+		return nil, fmt.Errorf("invalid rule %q: unknown scope %q, must be node, namespace, cluster or pods", rule, expr.scope)
+	}
+	_, isResource := resourceMetrics[expr.metric]
+	isCount := false
+	for _, metric := range counts {
+		isCount = isCount || metric == expr.metric
+	}
+	switch {
+	case isResource && expr.scope != "pods":
+	case isCount:
+		if expr.percent {
+			return nil, fmt.Errorf("invalid rule %q: %s is a count and cannot be a percentage", rule, expr.metric)
+		}
+	default:
+		return nil, fmt.Errorf("invalid rule %q: unknown metric %q for %s", rule, expr.metric, expr.scope)
+	}
+
+	if expr.percent {
+		value, err := strconv.ParseFloat(match[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %v", rule, err)
+		}
+		expr.threshold = value
+	} else {
+		value, err := resource.ParseQuantity(match[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %v", rule, err)
+		}
+		expr.threshold = value.AsApproximateFloat64()
+	}
+	return expr, nil
+}
+
+func (e *ruleExpr) compare(value float64) bool {
+	switch e.op {
+	case ">":
+		return value > e.threshold
+	case ">=":
+		return value >= e.threshold
+	case "<":
+		return value < e.threshold
+	case "<=":
+		return value <= e.threshold
+	case "==":
+		return value == e.threshold
+	default:
+		return value != e.threshold
+	}
+}
+
+// evaluate returns the rows the rule looked at. Node percentages are of node
+// capacity; namespace and cluster percentages are of cluster allocatable.
+func (e *ruleExpr) evaluate(state *checkState) ([]checkRow, error) {
+	if strings.HasSuffix(e.metric, "-usage") && state.metricsErr != nil {
+		return nil, fmt.Errorf("metrics are not available: %v", state.metricsErr)
+	}
+
+	var rows []checkRow
+	switch e.scope {
+	case "node":
+		for _, node := range state.nodes {
+			if e.metric == "pods" {
+				rows = append(rows, e.countRow(node.name, state.nodePods[node.name]))
+				continue
+			}
+			rows = append(rows, e.resourceRow(node.name, node.resources, node.resources[capacityKey(e.metric)]))
+		}
+	case "namespace":
+		for _, ns := range state.namespaces {
+			if e.metric == "pods" {
+				rows = append(rows, e.countRow(ns.name, ns.pods))
+				continue
+			}
+			rows = append(rows, e.resourceRow(ns.name, ns.resources, state.allocatable[allocatableKey(e.metric)]))
+		}
+	case "cluster":
+		switch e.metric {
+		case "nodes":
+			rows = append(rows, e.countRow("cluster", state.summary.nodes))
+		case "nodes-not-ready":
+			rows = append(rows, e.countRow("cluster", state.summary.nodes-state.summary.readyNodes))
+		case "nodes-cordoned":
+			rows = append(rows, e.countRow("cluster", state.summary.cordoned))
+		default:
+			// Same totals as the cluster view: requests and limits of scheduled pods
+			totals := map[string]*resource.Quantity{
+				"cpuReq":   state.summary.requested["cpu"],
+				"cpuLimit": state.summary.limits["cpu"],
+				"cpuUsage": state.summary.usage["cpu"],
+				"memReq":   state.summary.requested["memory"],
+				"memLimit": state.summary.limits["memory"],
+				"memUsage": state.summary.usage["memory"],
+			}
+			rows = append(rows, e.resourceRow("cluster", totals, state.allocatable[allocatableKey(e.metric)]))
+		}
+	case "pods":
+		// The count is the value; the matching pods are the offending rows
+		var matching []string
+		for _, pod := range state.pods {
+			if e.metric == "total" || podInState(pod, e.metric) {
+				matching = append(matching, pod.namespace+"/"+pod.name)
+			}
+		}
+		row := e.countRow("pods", len(matching))
+		if row.violates && e.metric != "total" && len(matching) > 0 {
+			sort.Strings(matching)
+			for _, name := range matching {
+				rows = append(rows, checkRow{subject: name, display: e.metric, violates: true})
+			}
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (e *ruleExpr) countRow(subject string, count int) checkRow {
+	return checkRow{subject: subject, display: strconv.Itoa(count), violates: e.compare(float64(count))}
+}
+
+func (e *ruleExpr) resourceRow(subject string, resources map[string]*resource.Quantity, capacity *resource.Quantity) checkRow {
+	q := resources[resourceMetrics[e.metric]]
+	if q == nil {
+		q = resource.NewQuantity(0, resource.DecimalSI)
+	}
+	if e.percent {
+		value := ratio(q, capacity) * 100
+		return checkRow{subject: subject, display: fmt.Sprintf("%.1f%%", value), violates: e.compare(value)}
+	}
+	value := q.AsApproximateFloat64()
+	return checkRow{subject: subject, display: formatQuantity(*q), violates: e.compare(value)}
+}
+
+// capacityKey is the node resource a metric is a percentage of
+func capacityKey(metric string) string {
+	return strings.SplitN(metric, "-", 2)[0] + "Capacity"
+}
+
+// allocatableKey is the cluster total a metric is a percentage of
+func allocatableKey(metric string) string {
+	if strings.HasPrefix(metric, "cpu") {
+		return "cpu"
+	}
+	return "memory"
+}
+
+func podInState(pod podInfo, state string) bool {
+	switch state {
+	case "pending":
+		return pod.phase == "Pending"
+	case "failed":
+		return pod.phase == "Failed"
+	case "crashlooping":
+		return pod.crashLooping
+	}
+	return false
+}
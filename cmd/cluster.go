@@ -0,0 +1,328 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// clusterTopN is the number of namespaces and nodes listed in the summary
+const clusterTopN = 5
+
+var clusterCmd = &cobra.Command{
+	Use:         "cluster",
+	Short:       "Summary of cluster capacity, allocation and pod health",
+	Annotations: map[string]string{multiClusterAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runClusterCommand()
+	},
+}
+
+type clusterSummary struct {
+	nodes        int
+	readyNodes   int
+	cordoned     int
+	allocatable  map[string]*resource.Quantity
+	requested    map[string]*resource.Quantity
+	limits       map[string]*resource.Quantity
+	usage        map[string]*resource.Quantity
+	pods         int
+	pending      int
+	failed       int
+	crashLooping int
+	metricsErr   error
+	namespaces   namespaceInfoList
+	nodesList    nodeInfoList
+}
+
+func runClusterCommand() error {
+	results := queryClusters(collectClusterSummary)
+	succeeded, err := succeededClusters(results)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	if !multiCluster() {
+		printClusterSummary(w, succeeded[0].value)
+		return nil
+	}
+	printClusterTable(w, succeeded)
+	printFailedClusters(results)
+	return nil
+}
+
+// collectClusterSummary reads nodes, pods and metrics of one cluster
+func collectClusterSummary(ctx context.Context, cluster string, src client.Source) (clusterSummary, error) {
+	// Get nodes, pods and metrics in parallel; metrics are optional for the summary
+	state, err := client.FetchState(ctx, src, client.FetchRequest{Nodes: true, Pods: true, PodMetrics: true})
+	if err != nil {
+		return clusterSummary{}, err
+	}
+
+	summary := getClusterSummary(state.Nodes, state.Pods, getPodInfoList(state.Pods, state.PodMetrics))
+	summary.metricsErr = state.MetricsErr
+	return summary, nil
+}
+
+// getClusterSummary folds the node and pod aggregations into cluster-wide totals
+func getClusterSummary(nodes []v1.Node, pods []v1.Pod, podsList podInfoList) clusterSummary {
+	summary := clusterSummary{
+		nodes:       len(nodes),
+		allocatable: newClusterTotals(),
+		requested:   newClusterTotals(),
+		limits:      newClusterTotals(),
+		usage:       newClusterTotals(),
+		nodesList:   getNodeInfoList(nodes, pods),
+		namespaces:  getNamespaceInfoList(podsList),
+	}
+
+	for _, node := range nodes {
+		if isNodeReady(&node) {
+			summary.readyNodes++
+		}
+		if node.Spec.Unschedulable {
+			summary.cordoned++
+		}
+		summary.allocatable["cpu"].Add(*node.Status.Allocatable.Cpu())
+		summary.allocatable["memory"].Add(*node.Status.Allocatable.Memory())
+		summary.allocatable["pods"].Add(*node.Status.Allocatable.Pods())
+		summary.allocatable["gpu"].Add(node.Status.Allocatable[gpuResource])
+	}
+
+	for _, node := range summary.nodesList {
+		summary.requested["cpu"].Add(*node.resources["cpuReq"])
+		summary.requested["memory"].Add(*node.resources["memReq"])
+		summary.requested["gpu"].Add(*node.resources["gpuReq"])
+		summary.limits["cpu"].Add(*node.resources["cpuLimit"])
+		summary.limits["memory"].Add(*node.resources["memLimit"])
+		summary.limits["gpu"].Add(*node.resources["gpuLimit"])
+	}
+
+	for _, pod := range podsList {
+		switch pod.phase {
+		case "Pending":
+			summary.pending++
+		case "Failed":
+			summary.failed++
+		}
+		if pod.crashLooping {
+			summary.crashLooping++
+		}
+		if pod.nodeName != "" && pod.phase != "Succeeded" && pod.phase != "Failed" {
+			summary.requested["pods"].Add(*resource.NewQuantity(1, resource.DecimalSI))
+		}
+		if pod.cpuUsage != nil {
+			summary.usage["cpu"].Add(*pod.cpuUsage)
+		}
+		if pod.memUsage != nil {
+			summary.usage["memory"].Add(*pod.memUsage)
+		}
+	}
+	summary.pods = len(podsList)
+
+	return summary
+}
+
+func newClusterTotals() map[string]*resource.Quantity {
+	return map[string]*resource.Quantity{
+		"cpu":    resource.NewQuantity(0, resource.DecimalSI),
+		"memory": resource.NewQuantity(0, resource.BinarySI),
+		"pods":   resource.NewQuantity(0, resource.DecimalSI),
+		"gpu":    resource.NewQuantity(0, resource.DecimalSI),
+	}
+}
+
+func printClusterSummary(w *tabwriter.Writer, summary clusterSummary) {
+	fmt.Fprintf(w, "Nodes:\t%d (%d Ready, %d NotReady, %d cordoned)\n",
+		summary.nodes, summary.readyNodes, summary.nodes-summary.readyNodes, summary.cordoned)
+	fmt.Fprintf(w, "Pods:\t%d (%d Pending, %d Failed, %d CrashLooping)\n",
+		summary.pods, summary.pending, summary.failed, summary.crashLooping)
+	if summary.metricsErr != nil {
+		fmt.Fprintf(w, "Metrics server:\tunavailable (%v)\n", summary.metricsErr)
+	} else {
+		fmt.Fprintf(w, "Metrics server:\tavailable\n")
+	}
+	w.Flush()
+
+	// Resource totals
+	fmt.Println()
+	fmt.Fprintln(w, strings.Join([]string{"RESOURCE", "ALLOCATABLE", "REQUESTED", "LIMITS", "USAGE", "LIMIT OVERCOMMIT"}, "\t"))
+	for _, key := range []string{"cpu", "memory", "pods", "gpu"} {
+		allocatable := summary.allocatable[key]
+		limits, usage, overcommit := "-", "-", "-"
+		if key != "pods" {
+			limits = formatShare(summary.limits[key], allocatable)
+			if !allocatable.IsZero() {
+				overcommit = fmt.Sprintf("%.2fx", ratio(summary.limits[key], allocatable))
+			}
+		}
+		if (key == "cpu" || key == "memory") && summary.metricsErr == nil {
+			usage = formatShare(summary.usage[key], allocatable)
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			key,
+			formatQuantity(*allocatable),
+			formatShare(summary.requested[key], allocatable),
+			limits,
+			usage,
+			overcommit,
+		}, "\t"))
+	}
+	w.Flush()
+
+	// Top namespaces by request
+	namespaces := summary.namespaces
+	sort.Slice(namespaces, func(i, j int) bool {
+		return dominantShare(namespaces[i].resources, summary.allocatable) > dominantShare(namespaces[j].resources, summary.allocatable)
+	})
+	fmt.Println()
+	fmt.Fprintln(w, strings.Join([]string{"TOP NAMESPACES", "PODS", "CPU REQ", "MEM REQ"}, "\t"))
+	for i, ns := range namespaces {
+		if i == clusterTopN {
+			break
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			ns.name,
+			fmt.Sprintf("%d", ns.pods),
+			formatShare(ns.resources["cpuReq"], summary.allocatable["cpu"]),
+			formatShare(ns.resources["memReq"], summary.allocatable["memory"]),
+		}, "\t"))
+	}
+	w.Flush()
+
+	// Top nodes by request
+	nodesList := summary.nodesList
+	sort.Slice(nodesList, func(i, j int) bool {
+		return nodeRequestShare(nodesList[i]) > nodeRequestShare(nodesList[j])
+	})
+	fmt.Println()
+	fmt.Fprintln(w, strings.Join([]string{"TOP NODES", "CPU REQ", "MEM REQ"}, "\t"))
+	for i, node := range nodesList {
+		if i == clusterTopN {
+			break
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			node.name,
+			formatShare(node.resources["cpuReq"], node.resources["cpuCapacity"]),
+			formatShare(node.resources["memReq"], node.resources["memCapacity"]),
+		}, "\t"))
+	}
+	w.Flush()
+}
+
+// printClusterTable prints one summary row per cluster followed by the cross-cluster total
+func printClusterTable(w *tabwriter.Writer, results []clusterResult[clusterSummary]) {
+	total := clusterSummary{
+		allocatable: newClusterTotals(),
+		requested:   newClusterTotals(),
+		usage:       newClusterTotals(),
+	}
+	withMetrics := 0
+
+	fmt.Fprintln(w, strings.Join([]string{"CLUSTER", "NODES", "PODS", "PENDING", "FAILED", "CRASHLOOP", "CPU REQ", "CPU USAGE", "MEM REQ", "MEM USAGE", "METRICS"}, "\t"))
+	for _, result := range results {
+		summary := result.value
+		fmt.Fprintln(w, strings.Join(clusterRow(result.cluster, summary, summary.metricsErr == nil, metricsStatus(summary.metricsErr == nil, summary.metricsErr == nil)), "\t"))
+
+		total.nodes += summary.nodes
+		total.readyNodes += summary.readyNodes
+		total.pods += summary.pods
+		total.pending += summary.pending
+		total.failed += summary.failed
+		total.crashLooping += summary.crashLooping
+		addResources(total.allocatable, summary.allocatable)
+		addResources(total.requested, summary.requested)
+		if summary.metricsErr == nil {
+			addResources(total.usage, summary.usage)
+			withMetrics++
+		}
+	}
+	fmt.Fprintln(w, strings.Join(clusterRow(totalLabel, total, withMetrics > 0, metricsStatus(withMetrics > 0, withMetrics == len(results))), "\t"))
+	w.Flush()
+}
+
+// clusterRow renders one line of the multi-cluster table
+func clusterRow(name string, summary clusterSummary, hasUsage bool, metrics string) []string {
+	cpuUsage, memUsage := "-", "-"
+	if hasUsage {
+		cpuUsage = formatShare(summary.usage["cpu"], summary.allocatable["cpu"])
+		memUsage = formatShare(summary.usage["memory"], summary.allocatable["memory"])
+	}
+	return []string{
+		name,
+		fmt.Sprintf("%d/%d", summary.readyNodes, summary.nodes),
+		fmt.Sprintf("%d", summary.pods),
+		fmt.Sprintf("%d", summary.pending),
+		fmt.Sprintf("%d", summary.failed),
+		fmt.Sprintf("%d", summary.crashLooping),
+		formatShare(summary.requested["cpu"], summary.allocatable["cpu"]),
+		cpuUsage,
+		formatShare(summary.requested["memory"], summary.allocatable["memory"]),
+		memUsage,
+		metrics,
+	}
+}
+
+// metricsStatus describes metrics-server availability, partial when only some clusters have it
+func metricsStatus(some, all bool) string {
+	switch {
+	case all:
+		return "available"
+	case some:
+		return "partial"
+	default:
+		return "unavailable"
+	}
+}
+
+// ratio returns q/total, or 0 when total is zero
+func ratio(q, total *resource.Quantity) float64 {
+	if total == nil || total.IsZero() || q == nil {
+		return 0
+	}
+	return float64(q.MilliValue()) / float64(total.MilliValue())
+}
+
+// formatShare renders a quantity with its percentage of total
+func formatShare(q, total *resource.Quantity) string {
+	if total == nil || total.IsZero() {
+		return formatQuantity(*q)
+	}
+	return fmt.Sprintf("%s (%.0f%%)", formatQuantity(*q), ratio(q, total)*100)
+}
+
+// dominantShare returns the larger of the CPU and memory request shares of the cluster
+func dominantShare(resources map[string]*resource.Quantity, allocatable map[string]*resource.Quantity) float64 {
+	cpu := ratio(resources["cpuReq"], allocatable["cpu"])
+	mem := ratio(resources["memReq"], allocatable["memory"])
+	if cpu > mem {
+		return cpu
+	}
+	return mem
+}
+
+// nodeRequestShare returns the larger of the CPU and memory request shares of a node
+func nodeRequestShare(node nodeInfo) float64 {
+	cpu := ratio(node.resources["cpuReq"], node.resources["cpuCapacity"])
+	mem := ratio(node.resources["memReq"], node.resources["memCapacity"])
+	if cpu > mem {
+		return cpu
+	}
+	return mem
+}
+
+func init() {
+	rootCmd.AddCommand(clusterCmd)
+}
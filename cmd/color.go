@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// All color codes have the same length, so tabwriter keeps columns aligned as
+// long as every cell of a painted column goes through paint, using colorNone
+// for cells that should stay uncolored.
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorNone   = "\x1b[39m"
+)
+
+// barWidth is the number of cells of an inline utilisation bar
+const barWidth = 10
+
+// barEighths are the partial blocks used for the last cell of a bar
+var barEighths = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+var (
+	colorMode     string
+	showBars      bool
+	warnThreshold float64
+	critThreshold float64
+)
+
+// colorEnabled is true when stdout is a terminal and NO_COLOR is not set
+var colorEnabled = os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+
+// paint wraps s in an ANSI color when colors are enabled
+func paint(color, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// thresholdColor is green below --warn-threshold, yellow below --crit-threshold and red above
+func thresholdColor(percent float64) string {
+	switch {
+	case percent >= critThreshold:
+		return colorRed
+	case percent >= warnThreshold:
+		return colorYellow
+	default:
+		return colorGreen
+	}
+}
+
+// percentCell renders a value with its percentage colored by threshold, and
+// with --bars an inline bar in front of the percentage. Exactly one painted
+// segment is emitted, like plainCell, so colored columns stay aligned.
+func percentCell(value string, percent float64, format string) string {
+	if showBars {
+		return value + " " + paint(thresholdColor(percent), utilisationBar(percent)+" "+fmt.Sprintf(format, percent))
+	}
+	return value + " (" + paint(thresholdColor(percent), fmt.Sprintf(format, percent)) + ")"
+}
+
+// plainCell renders an uncolored cell of a column that also has percentCell cells
+func plainCell(value string) string {
+	return paint(colorNone, value)
+}
+
+// ratioCell renders a percentage without a value in the given color, with
+// --bars an inline bar in front of it
+func ratioCell(color, text string, percent float64) string {
+	if showBars {
+		return paint(color, utilisationBar(percent)+" "+text)
+	}
+	return paint(color, text)
+}
+
+// utilisationBar draws percent as [████▌     ], capped at a full bar
+func utilisationBar(percent float64) string {
+	eighths := int(math.Round(math.Min(math.Max(percent, 0), 100) / 100 * barWidth * 8))
+	full, partial := eighths/8, eighths%8
+	bar := strings.Repeat("█", full) + barEighths[partial]
+	cells := full
+	if partial > 0 {
+		cells++
+	}
+	return "[" + bar + strings.Repeat(" ", barWidth-cells) + "]"
+}
+
+// validateDisplayFlags applies --color and checks the thresholds
+func validateDisplayFlags() error {
+	switch colorMode {
+	case "auto":
+	case "always":
+		colorEnabled = true
+	case "never":
+		colorEnabled = false
+	default:
+		return fmt.Errorf("invalid --color %q: must be auto, always or never", colorMode)
+	}
+	if warnThreshold > critThreshold {
+		return fmt.Errorf("--warn-threshold (%g) must not be above --crit-threshold (%g)", warnThreshold, critThreshold)
+	}
+	return nil
+}
+
+// addDisplayFlags adds the color and bar flags to a command
+func addDisplayFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&colorMode, "color", "auto", "Color percentages: auto (when stdout is a terminal and NO_COLOR is unset), always, never")
+	cmd.Flags().BoolVar(&showBars, "bars", false, "Show inline utilisation bars next to percentages")
+	cmd.Flags().Float64Var(&warnThreshold, "warn-threshold", 70, "Percentage from which values are yellow")
+	cmd.Flags().Float64Var(&critThreshold, "crit-threshold", 90, "Percentage from which values are red")
+}
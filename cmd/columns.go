@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const customColumnsPrefix = "custom-columns="
+
+// costColumnIDs are the columns that need a price table
+var costColumnIDs = []string{"cost-hr", "cost-month", "idle-hr"}
+
+var (
+	columnIDs    []string
+	outputFormat string
+)
+
+// customColumn is a -o custom-columns entry, evaluated against the Node or Pod object
+type customColumn struct {
+	header string
+	path   *jsonpath.JSONPath
+}
+
+// toColumnID converts a camelCase key to a --columns id
+// e.g.: "cpuCapacity" -> "cpu-capacity", "memUsage (%)" -> "mem-usage"
+func toColumnID(key string) string {
+	key = strings.TrimSuffix(key, " (%)")
+	var id strings.Builder
+	for i, r := range key {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				id.WriteByte('-')
+			}
+			r += 'a' - 'A'
+		}
+		id.WriteRune(r)
+	}
+	return id.String()
+}
+
+// selectColumns picks columns from a catalog by id, in the order given
+func selectColumns[C any](catalog []C, idOf func(C) string, ids []string) ([]C, error) {
+	byID := make(map[string]C, len(catalog))
+	valid := make([]string, 0, len(catalog))
+	for _, col := range catalog {
+		byID[idOf(col)] = col
+		valid = append(valid, idOf(col))
+	}
+
+	selected := make([]C, 0, len(ids))
+	for _, id := range ids {
+		col, ok := byID[strings.TrimSpace(id)]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, valid columns are: %s", id, strings.Join(valid, ", "))
+		}
+		selected = append(selected, col)
+	}
+	return selected, nil
+}
+
+// requestedColumnIDs returns the --columns ids, or the defaults when the flag
+// is not set. Custom columns replace the defaults instead of being appended.
+func requestedColumnIDs(defaults []string, custom []customColumn) []string {
+	if len(columnIDs) > 0 {
+		return columnIDs
+	}
+	if len(custom) > 0 {
+		return nil
+	}
+	return defaults
+}
+
+// validateCostColumns rejects cost columns when no price table is given
+func validateCostColumns(ids []string, priced bool) error {
+	if priced {
+		return nil
+	}
+	for _, id := range ids {
+		if slices.Contains(costColumnIDs, strings.TrimSpace(id)) {
+			return fmt.Errorf("column %s needs --price-table", id)
+		}
+	}
+	return nil
+}
+
+// parseOutputFormat parses -o, which is either empty or custom-columns=HEADER:jsonpath,...
+func parseOutputFormat() ([]customColumn, error) {
+	if outputFormat == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(outputFormat, customColumnsPrefix) {
+		return nil, fmt.Errorf("unsupported output format %q, expected custom-columns=HEADER:jsonpath,...", outputFormat)
+	}
+
+	var custom []customColumn
+	for _, spec := range strings.Split(strings.TrimPrefix(outputFormat, customColumnsPrefix), ",") {
+		header, expr, ok := strings.Cut(spec, ":")
+		if !ok || header == "" || expr == "" {
+			return nil, fmt.Errorf("custom column %q is not in HEADER:jsonpath form", spec)
+		}
+		path := jsonpath.New(header).AllowMissingKeys(true)
+		if err := path.Parse(relaxedJSONPath(expr)); err != nil {
+			return nil, fmt.Errorf("custom column %s: %w", header, err)
+		}
+		custom = append(custom, customColumn{header: header, path: path})
+	}
+
+	// Custom columns may read any field, so keep objects as the API returned them
+	client.FullObjects = true
+	return custom, nil
+}
+
+// relaxedJSONPath accepts kubectl's short forms: .metadata.name and metadata.name
+// both become {.metadata.name}
+func relaxedJSONPath(expr string) string {
+	if strings.HasPrefix(expr, "{") {
+		return expr
+	}
+	return "{." + strings.TrimPrefix(expr, ".") + "}"
+}
+
+// value evaluates the column against obj, joining several results with commas
+func (c customColumn) value(obj runtime.Object) string {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "<error>"
+	}
+	results, err := c.path.FindResults(content)
+	if err != nil {
+		return "<error>"
+	}
+
+	var values []string
+	for _, result := range results {
+		for _, value := range result {
+			var buf bytes.Buffer
+			if err := c.path.PrintResults(&buf, []reflect.Value{value}); err != nil {
+				return "<error>"
+			}
+			values = append(values, buf.String())
+		}
+	}
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ",")
+}
+
+func addColumnFlags(cmd *cobra.Command, ids []string) {
+	cmd.Flags().StringSliceVar(&columnIDs, "columns", nil, "Comma separated columns to show, in order: "+strings.Join(ids, ", "))
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Output format: custom-columns=HEADER:jsonpath,... evaluated against the underlying object")
+}
@@ -0,0 +1,269 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix turns a flag such as --sort-by into XTOP_SORT_BY
+const envPrefix = "XTOP_"
+
+var (
+	configFile  string
+	profileName string
+)
+
+// xtopConfig is the config file. Every section maps flag names to values; a
+// nested section named after a command, such as nodes or pods, only applies
+// to that command and wins over the shared values of its section.
+//
+//	defaults:
+//	  warn-threshold: 75
+//	  pods:
+//	    sort-by: mem-req
+//	profiles:
+//	  gpu:
+//	    nodes:
+//	      columns: [name, gpu-capacity, gpu-req, gpu-limit]
+//	contexts:
+//	  prod:
+//	    crit-threshold: 80
+type xtopConfig struct {
+	Defaults configSettings            `json:"defaults"`
+	Profiles map[string]configSettings `json:"profiles"`
+	Contexts map[string]configSettings `json:"contexts"`
+}
+
+type configSettings map[string]interface{}
+
+// defaultConfigFile is $XDG_CONFIG_HOME/kubectl-xtop/config.yaml, falling back to ~/.config
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "kubectl-xtop", "config.yaml")
+}
+
+// loadConfig reads the config file. A missing default file is not an error,
+// a missing file given with --config or XTOP_CONFIG is.
+func loadConfig(cmd *cobra.Command) (*xtopConfig, string, error) {
+	path := configFile
+	explicit := cmd.Flags().Changed("config")
+	if !explicit {
+		if env := os.Getenv(envPrefix + "CONFIG"); env != "" {
+			path, explicit = env, true
+		} else {
+			path = defaultConfigFile()
+		}
+	}
+
+	config := &xtopConfig{}
+	if path == "" {
+		return config, path, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return config, path, nil
+	}
+	if err != nil {
+		return nil, path, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, path, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, path, nil
+}
+
+// applyConfig sets every flag of cmd that was not given on the command line
+// from, in increasing order of precedence, the config defaults, the section of
+// the current kubeconfig context, the selected profile and XTOP_* variables
+func applyConfig(cmd *cobra.Command) error {
+	config, path, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(cmd.Root(), config, path); err != nil {
+		return err
+	}
+
+	profile := profileName
+	if !cmd.Flags().Changed("profile") {
+		if env := os.Getenv(envPrefix + "PROFILE"); env != "" {
+			profile = env
+		}
+	}
+	var profileSettings configSettings
+	if profile != "" {
+		var ok bool
+		if profileSettings, ok = config.Profiles[profile]; !ok {
+			return fmt.Errorf("profile %q is not defined in %s, defined profiles: %s", profile, path, strings.Join(sortedKeys(config.Profiles), ", "))
+		}
+	}
+	envSettings := configSettings{}
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if value, ok := os.LookupEnv(flagEnvName(flag.Name)); ok {
+			envSettings[flag.Name] = value
+		}
+	})
+
+	defaults := config.Defaults.forCommand(cmd.Name())
+	selected := profileSettings.forCommand(cmd.Name())
+
+	// Context sections only make sense when a single cluster is queried
+	var contextSettings configSettings
+	if len(config.Contexts) > 0 && !querySeveralContexts(cmd, mergeSettings(defaults, selected, envSettings)) {
+		contextSettings = config.Contexts[client.CurrentContext()].forCommand(cmd.Name())
+	}
+
+	settings := mergeSettings(defaults, contextSettings, selected, envSettings)
+	for _, name := range sortedKeys(settings) {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := setFlag(cmd.Flags(), name, settings[name]); err != nil {
+			return fmt.Errorf("%s: --%s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// forCommand returns the shared settings overridden by the section of the command
+func (s configSettings) forCommand(name string) configSettings {
+	settings := configSettings{}
+	for key, value := range s {
+		if _, ok := value.(map[string]interface{}); !ok {
+			settings[key] = value
+		}
+	}
+	if section, ok := s[name].(map[string]interface{}); ok {
+		for key, value := range section {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+func mergeSettings(layers ...configSettings) configSettings {
+	merged := configSettings{}
+	for _, layer := range layers {
+		for key, value := range layer {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// querySeveralContexts reports whether --contexts or --all-contexts is set,
+// on the command line or by settings
+func querySeveralContexts(cmd *cobra.Command, settings configSettings) bool {
+	if cmd.Flags().Changed("contexts") || cmd.Flags().Changed("all-contexts") {
+		return true
+	}
+	_, contexts := settings["contexts"]
+	return contexts || fmt.Sprint(settings["all-contexts"]) == "true"
+}
+
+// validateConfig rejects settings that are no flag of any command, so typos do not go unnoticed
+func validateConfig(root *cobra.Command, config *xtopConfig, path string) error {
+	flags := make(map[string]bool)
+	commands := make(map[string]bool)
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		commands[cmd.Name()] = true
+		cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) { flags[flag.Name] = true })
+		for _, child := range cmd.Commands() {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	check := func(section string, settings configSettings) error {
+		for _, key := range sortedKeys(settings) {
+			if nested, ok := settings[key].(map[string]interface{}); ok && commands[key] {
+				for nestedKey := range nested {
+					if !flags[nestedKey] {
+						return fmt.Errorf("%s: %s.%s: unknown flag %q", path, section, key, nestedKey)
+					}
+				}
+				continue
+			}
+			if !flags[key] {
+				return fmt.Errorf("%s: %s: unknown flag %q", path, section, key)
+			}
+		}
+		return nil
+	}
+
+	if err := check("defaults", config.Defaults); err != nil {
+		return err
+	}
+	for name, settings := range config.Profiles {
+		if err := check("profiles."+name, settings); err != nil {
+			return err
+		}
+	}
+	for name, settings := range config.Contexts {
+		if err := check("contexts."+name, settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlag sets a flag from a config or environment value. Lists set the flag
+// once per item, so both slice and array flags get every item.
+func setFlag(flags *pflag.FlagSet, name string, value interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if err := setFlag(flags, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case float64:
+		return flags.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		return flags.Set(name, strconv.FormatBool(v))
+	case string:
+		return flags.Set(name, v)
+	default:
+		return fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file with flag defaults, profiles and per-context overrides (default $XDG_CONFIG_HOME/kubectl-xtop/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "apply a named profile from the config file")
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// hoursPerMonth is the average number of hours in a month used for monthly estimates
+const hoursPerMonth = 730
+
+var (
+	priceTableFile string
+	costMode       string
+)
+
+// priceTable maps instance type and capacity type to an hourly node price
+type priceTable struct {
+	Default float64      `json:"default"`
+	Prices  []priceEntry `json:"prices"`
+}
+
+type priceEntry struct {
+	InstanceType string  `json:"instanceType"`
+	CapacityType string  `json:"capacityType"`
+	Hourly       float64 `json:"hourly"`
+}
+
+// nodeCost is the hourly price of a node and the part of it allocated to pods
+type nodeCost struct {
+	hourly    float64
+	allocated float64
+}
+
+func (c *nodeCost) idle() float64 {
+	if c.allocated > c.hourly {
+		return 0
+	}
+	return c.hourly - c.allocated
+}
+
+// loadPriceTable reads a YAML or CSV price table
+func loadPriceTable(path string) (*priceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return parseCSVPriceTable(string(data))
+	}
+
+	table := &priceTable{}
+	if err := yaml.UnmarshalStrict(data, table); err != nil {
+		return nil, fmt.Errorf("parsing price table %s: %v", path, err)
+	}
+	for i := range table.Prices {
+		table.Prices[i].CapacityType = normalizeCapacityType(table.Prices[i].CapacityType)
+	}
+	return table, nil
+}
+
+// parseCSVPriceTable reads "instance-type,capacity-type,hourly" rows, with an optional header.
+// A row with instance type "*" sets the default price.
+func parseCSVPriceTable(data string) (*priceTable, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	table := &priceTable{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing price table: %v", err)
+		}
+
+		hourly, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("parsing price table line %d: invalid price %q", line, record[2])
+		}
+		if record[0] == "*" {
+			table.Default = hourly
+			continue
+		}
+		table.Prices = append(table.Prices, priceEntry{
+			InstanceType: record[0],
+			CapacityType: normalizeCapacityType(record[1]),
+			Hourly:       hourly,
+		})
+	}
+	return table, nil
+}
+
+// nodePrice returns the hourly price of a node, preferring an exact capacity type match
+func (t *priceTable) nodePrice(meta map[string]string) (float64, bool) {
+	var fallback *priceEntry
+	for i := range t.Prices {
+		entry := &t.Prices[i]
+		if entry.InstanceType != meta["type"] {
+			continue
+		}
+		if entry.CapacityType == meta["capacityType"] {
+			return entry.Hourly, true
+		}
+		if entry.CapacityType == "" && fallback == nil {
+			fallback = entry
+		}
+	}
+	if fallback != nil {
+		return fallback.Hourly, true
+	}
+	if t.Default > 0 {
+		return t.Default, true
+	}
+	return 0, false
+}
+
+// nodeCapacityType reads the capacity type from the labels set by common node provisioners
+func nodeCapacityType(labels map[string]string) string {
+	if v, ok := labels["karpenter.sh/capacity-type"]; ok {
+		return normalizeCapacityType(v)
+	}
+	if v, ok := labels["eks.amazonaws.com/capacityType"]; ok {
+		return normalizeCapacityType(v)
+	}
+	if labels["cloud.google.com/gke-spot"] == "true" || labels["cloud.google.com/gke-preemptible"] == "true" {
+		return "spot"
+	}
+	if v, ok := labels["kubernetes.azure.com/scalesetpriority"]; ok && v == "spot" {
+		return "spot"
+	}
+	return "on-demand"
+}
+
+func normalizeCapacityType(value string) string {
+	switch strings.ToLower(strings.ReplaceAll(value, "_", "-")) {
+	case "":
+		return ""
+	case "spot", "preemptible":
+		return "spot"
+	default:
+		return "on-demand"
+	}
+}
+
+// applyCosts prices every node and splits the price across its pods by their
+// share of the node's CPU and memory capacity. Whatever is not allocated to
+// pods is reported as idle.
+func applyCosts(table *priceTable, nodesList nodeInfoList, podsList podInfoList) {
+	nodesByName := make(map[string]*nodeInfo, len(nodesList))
+	for i := range nodesList {
+		node := &nodesList[i]
+		if price, ok := table.nodePrice(node.meta); ok {
+			node.cost = &nodeCost{hourly: price}
+		}
+		nodesByName[node.name] = node
+	}
+
+	for i := range podsList {
+		pod := &podsList[i]
+		if pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+		node, ok := nodesByName[pod.nodeName]
+		if !ok || node.cost == nil {
+			continue
+		}
+
+		cpuShare := ratio(costBasis(pod.resources["cpuReq"], pod.cpuUsage), node.resources["cpuCapacity"])
+		memShare := ratio(costBasis(pod.resources["memReq"], pod.memUsage), node.resources["memCapacity"])
+		pod.hourlyCost = node.cost.hourly * (min(cpuShare, 1) + min(memShare, 1)) / 2
+		node.cost.allocated += pod.hourlyCost
+	}
+}
+
+// costBasis returns the quantity a pod is charged for according to --cost-mode
+func costBasis(request, usage *resource.Quantity) *resource.Quantity {
+	if costMode == "usage" && usage != nil && (request == nil || usage.Cmp(*request) > 0) {
+		return usage
+	}
+	return request
+}
+
+func formatCost(hourly float64) string {
+	return fmt.Sprintf("%.3f", hourly)
+}
+
+func formatMonthlyCost(hourly float64) string {
+	return fmt.Sprintf("%.2f", hourly*hoursPerMonth)
+}
+
+// validateCostFlags loads the price table when one was given
+func validateCostFlags() (*priceTable, error) {
+	if costMode != "requests" && costMode != "usage" {
+		return nil, fmt.Errorf("invalid --cost-mode %q: must be requests or usage", costMode)
+	}
+	if priceTableFile == "" {
+		return nil, nil
+	}
+	return loadPriceTable(priceTableFile)
+}
+
+func addCostFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&priceTableFile, "price-table", "", "YAML or CSV price table mapping instance type and capacity type to an hourly price; adds cost columns")
+	cmd.Flags().StringVar(&costMode, "cost-mode", "requests", "Split node cost by: requests, usage (max of request and usage)")
+}
@@ -0,0 +1,364 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	diffOutput string
+)
+
+// diffKeys are the resource keys compared for nodes and namespaces
+var diffKeys = []string{"cpuReq", "cpuLimit", "cpuUsage", "memReq", "memLimit", "memUsage"}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff SNAPSHOT_A [SNAPSHOT_B]",
+	Short: "Show how allocation changed between two snapshots",
+	Long: `Compare two snapshots (files from 'xtop snapshot save' or kubectl dump
+directories). Without a second argument the first snapshot is compared
+against the live cluster, or whatever --from-file/--from-dir point to.`,
+	Args: cobra.RangeArgs(1, 2),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+		// The current cluster is only needed when it is one side of the diff
+		if len(args) == 2 {
+			return nil
+		}
+		return initDataSource(cmd)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDiffCommand(args)
+	},
+}
+
+// diffState is one side of a diff, keyed for lookups
+type diffState struct {
+	nodes      map[string]nodeInfo
+	namespaces map[string]namespaceInfo
+	pods       map[string]podInfo
+	workloads  map[string]string
+}
+
+type diffReport struct {
+	From         string          `json:"from"`
+	To           string          `json:"to"`
+	NodesAdded   []string        `json:"nodesAdded"`
+	NodesRemoved []string        `json:"nodesRemoved"`
+	Nodes        []resourceDelta `json:"nodes"`
+	Namespaces   []resourceDelta `json:"namespaces"`
+	Workloads    []workloadDelta `json:"workloads"`
+	PodsAdded    []string        `json:"podsAdded"`
+	PodsRemoved  []string        `json:"podsRemoved"`
+}
+
+type resourceDelta struct {
+	Name    string                   `json:"name"`
+	Changes map[string]quantityDelta `json:"changes"`
+}
+
+type quantityDelta struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Delta  string `json:"delta"`
+	sign   int
+}
+
+type workloadDelta struct {
+	Workload string `json:"workload"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+func runDiffCommand(args []string) error {
+	if diffOutput != "table" && diffOutput != "json" {
+		return fmt.Errorf("invalid output %q: must be table or json", diffOutput)
+	}
+
+	before, err := loadSnapshotPath(args[0])
+	if err != nil {
+		return err
+	}
+	fromState, err := loadDiffState(client.NewSnapshotSource(before))
+	if err != nil {
+		return err
+	}
+
+	toLabel := "live"
+	toSource := dataSource
+	if len(args) == 2 {
+		after, err := loadSnapshotPath(args[1])
+		if err != nil {
+			return err
+		}
+		toLabel = args[1]
+		toSource = client.NewSnapshotSource(after)
+	}
+	toState, err := loadDiffState(toSource)
+	if err != nil {
+		return err
+	}
+
+	report := diffStates(fromState, toState)
+	report.From = args[0]
+	report.To = toLabel
+
+	if diffOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	printDiffReport(w, report)
+	return nil
+}
+
+// loadDiffState aggregates a source the same way the nodes, pods and namespaces views do
+func loadDiffState(src client.Source) (*diffState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	fetched, err := client.FetchState(ctx, src, client.FetchRequest{Nodes: true, Pods: true, PodMetrics: true})
+	if err != nil {
+		return nil, err
+	}
+	nodes, pods := fetched.Nodes, fetched.Pods
+	podsList := getPodInfoList(pods, fetched.PodMetrics)
+
+	state := &diffState{
+		nodes:      make(map[string]nodeInfo),
+		namespaces: make(map[string]namespaceInfo),
+		pods:       make(map[string]podInfo),
+		workloads:  make(map[string]string),
+	}
+
+	for _, node := range getNodeInfoList(nodes, pods) {
+		node.resources["cpuUsage"] = resource.NewQuantity(0, resource.DecimalSI)
+		node.resources["memUsage"] = resource.NewQuantity(0, resource.BinarySI)
+		state.nodes[node.name] = node
+	}
+	for _, pod := range podsList {
+		state.pods[pod.namespace+"/"+pod.name] = pod
+		if node, ok := state.nodes[pod.nodeName]; ok {
+			node.resources["cpuUsage"].Add(*pod.cpuUsage)
+			node.resources["memUsage"].Add(*pod.memUsage)
+		}
+	}
+	for _, ns := range getNamespaceInfoList(podsList) {
+		state.namespaces[ns.name] = ns
+	}
+
+	// A workload's shape is the one most of its active pods have, so a
+	// rollout in progress does not count as a change
+	shapes := make(map[string]map[string]int)
+	for _, pod := range podsList {
+		if pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+		key := pod.workload.String()
+		if shapes[key] == nil {
+			shapes[key] = make(map[string]int)
+		}
+		shapes[key][podShape(pod)]++
+	}
+	for key, counts := range shapes {
+		best := ""
+		for shape, n := range counts {
+			if best == "" || n > counts[best] || (n == counts[best] && shape < best) {
+				best = shape
+			}
+		}
+		state.workloads[key] = best
+	}
+	return state, nil
+}
+
+// podShape describes the requests and limits of a single replica
+func podShape(pod podInfo) string {
+	return fmt.Sprintf("cpu %s/%s mem %s/%s",
+		formatQuantity(*pod.resources["cpuReq"]), formatQuantity(*pod.resources["cpuLimit"]),
+		formatQuantity(*pod.resources["memReq"]), formatQuantity(*pod.resources["memLimit"]))
+}
+
+func diffStates(from, to *diffState) diffReport {
+	report := diffReport{}
+
+	for name := range to.nodes {
+		if _, ok := from.nodes[name]; !ok {
+			report.NodesAdded = append(report.NodesAdded, name)
+		}
+	}
+	for name, before := range from.nodes {
+		after, ok := to.nodes[name]
+		if !ok {
+			report.NodesRemoved = append(report.NodesRemoved, name)
+			continue
+		}
+		if delta := diffResources(name, before.resources, after.resources); delta != nil {
+			report.Nodes = append(report.Nodes, *delta)
+		}
+	}
+
+	empty := make(map[string]*resource.Quantity)
+	for name, before := range from.namespaces {
+		afterResources := empty
+		if after, ok := to.namespaces[name]; ok {
+			afterResources = after.resources
+		}
+		if delta := diffResources(name, before.resources, afterResources); delta != nil {
+			report.Namespaces = append(report.Namespaces, *delta)
+		}
+	}
+	for name, after := range to.namespaces {
+		if _, ok := from.namespaces[name]; !ok {
+			if delta := diffResources(name, empty, after.resources); delta != nil {
+				report.Namespaces = append(report.Namespaces, *delta)
+			}
+		}
+	}
+
+	for key, before := range from.workloads {
+		if after, ok := to.workloads[key]; ok && after != before {
+			report.Workloads = append(report.Workloads, workloadDelta{Workload: key, Before: before, After: after})
+		}
+	}
+
+	for key := range to.pods {
+		if _, ok := from.pods[key]; !ok {
+			report.PodsAdded = append(report.PodsAdded, key)
+		}
+	}
+	for key := range from.pods {
+		if _, ok := to.pods[key]; !ok {
+			report.PodsRemoved = append(report.PodsRemoved, key)
+		}
+	}
+
+	sort.Strings(report.NodesAdded)
+	sort.Strings(report.NodesRemoved)
+	sort.Strings(report.PodsAdded)
+	sort.Strings(report.PodsRemoved)
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].Name < report.Nodes[j].Name })
+	sort.Slice(report.Namespaces, func(i, j int) bool { return report.Namespaces[i].Name < report.Namespaces[j].Name })
+	sort.Slice(report.Workloads, func(i, j int) bool { return report.Workloads[i].Workload < report.Workloads[j].Workload })
+	return report
+}
+
+// diffResources returns the changed keys, or nil when nothing changed
+func diffResources(name string, before, after map[string]*resource.Quantity) *resourceDelta {
+	delta := &resourceDelta{Name: name, Changes: make(map[string]quantityDelta)}
+	changed := false
+	for _, key := range diffKeys {
+		b, a := resource.Quantity{}, resource.Quantity{}
+		if q := before[key]; q != nil {
+			b = q.DeepCopy()
+		}
+		if q := after[key]; q != nil {
+			a = q.DeepCopy()
+		}
+		d := a.DeepCopy()
+		d.Sub(b)
+
+		change := quantityDelta{Before: formatQuantity(b), After: formatQuantity(a), Delta: formatQuantity(d), sign: d.Sign()}
+		if d.Sign() > 0 {
+			change.Delta = "+" + change.Delta
+		}
+		if d.Sign() != 0 {
+			changed = true
+		}
+		delta.Changes[key] = change
+	}
+	if !changed {
+		return nil
+	}
+	return delta
+}
+
+func printDiffReport(w *tabwriter.Writer, report diffReport) {
+	fmt.Printf("Comparing %s -> %s\n", report.From, report.To)
+	if len(report.NodesAdded)+len(report.NodesRemoved)+len(report.Nodes)+len(report.Namespaces)+
+		len(report.Workloads)+len(report.PodsAdded)+len(report.PodsRemoved) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+	if len(report.NodesAdded) > 0 {
+		fmt.Printf("Nodes added: %s\n", strings.Join(report.NodesAdded, ", "))
+	}
+	if len(report.NodesRemoved) > 0 {
+		fmt.Printf("Nodes removed: %s\n", strings.Join(report.NodesRemoved, ", "))
+	}
+
+	printDeltaTable(w, "NODE", report.Nodes)
+	printDeltaTable(w, "NAMESPACE", report.Namespaces)
+
+	if len(report.Workloads) > 0 {
+		fmt.Println()
+		fmt.Fprintln(w, strings.Join([]string{"WORKLOAD", "BEFORE (REQ/LIMIT)", "AFTER (REQ/LIMIT)"}, "\t"))
+		for _, wl := range report.Workloads {
+			fmt.Fprintln(w, strings.Join([]string{wl.Workload, wl.Before, wl.After}, "\t"))
+		}
+		w.Flush()
+	}
+
+	if len(report.PodsAdded)+len(report.PodsRemoved) > 0 {
+		fmt.Println()
+		fmt.Fprintln(w, strings.Join([]string{"POD", "CHANGE"}, "\t"))
+		for _, pod := range report.PodsAdded {
+			fmt.Fprintln(w, strings.Join([]string{pod, paint(colorRed, "added")}, "\t"))
+		}
+		for _, pod := range report.PodsRemoved {
+			fmt.Fprintln(w, strings.Join([]string{pod, paint(colorGreen, "removed")}, "\t"))
+		}
+		w.Flush()
+	}
+}
+
+// printDeltaTable prints one row per changed entry. Growth is red, shrinkage green.
+func printDeltaTable(w *tabwriter.Writer, title string, deltas []resourceDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	fmt.Println()
+
+	headers := []string{title}
+	for _, key := range diffKeys {
+		headers = append(headers, paint(colorNone, toColumnName(key)))
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	for _, delta := range deltas {
+		row := []string{delta.Name}
+		for _, key := range diffKeys {
+			change := delta.Changes[key]
+			color := colorNone
+			switch {
+			case change.sign > 0:
+				color = colorRed
+			case change.sign < 0:
+				color = colorGreen
+			}
+			row = append(row, paint(color, fmt.Sprintf("%s (%s)", change.Delta, change.After)))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "table", "Output format: table, json")
+}
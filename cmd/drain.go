@@ -0,0 +1,299 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	drainSelector string
+)
+
+var drainSimCmd = &cobra.Command{
+	Use:   "drain-sim [NODE...]",
+	Short: "Simulate draining nodes and check the rest of the cluster can absorb their pods",
+	Long: `Take the pods that a drain would evict from the given nodes (DaemonSet and
+mirror pods are skipped), check them against PodDisruptionBudgets and
+first-fit them onto the remaining nodes by requests, taints, nodeSelector
+and required node affinity.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDrainSimCommand(args)
+	},
+}
+
+type evictedPod struct {
+	pod      *v1.Pod
+	requests v1.ResourceList
+	target   string
+	reason   string
+}
+
+type pdbImpact struct {
+	pdb     *policyv1.PodDisruptionBudget
+	evicted int
+}
+
+func runDrainSimCommand(args []string) error {
+	if len(args) == 0 && drainSelector == "" {
+		return fmt.Errorf("specify node names or a label selector with -l")
+	}
+	if len(args) > 0 && drainSelector != "" {
+		return fmt.Errorf("node names cannot be combined with -l")
+	}
+
+	// Get nodes, pods and PDBs in parallel
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{Nodes: true, Pods: true, PodDisruptionBudgets: true})
+	if err != nil {
+		return err
+	}
+
+	drained, err := drainTargets(state.Nodes, args)
+	if err != nil {
+		return err
+	}
+
+	allocations := buildNodeAllocations(state.Nodes, state.Pods)
+	for name := range drained {
+		delete(allocations, name)
+	}
+
+	evicted := evictablePods(state.Pods, drained)
+	placeEvictedPods(evicted, allocations)
+	impacts := pdbImpacts(state.PodDisruptionBudgets, evicted)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	printEvictionTable(w, evicted)
+
+	pending := 0
+	for _, e := range evicted {
+		if e.target == "" {
+			pending++
+		}
+	}
+
+	blocking := 0
+	for _, impact := range impacts {
+		if int32(impact.evicted) > impact.pdb.Status.DisruptionsAllowed {
+			blocking++
+		}
+	}
+	if len(impacts) > 0 {
+		fmt.Println()
+		printPDBTable(w, impacts)
+	}
+
+	names := make([]string, 0, len(drained))
+	for name := range drained {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("\nDrained nodes: %s\n", strings.Join(names, ", "))
+	fmt.Printf("Pods evicted: %d, rescheduled: %d, pending: %d\n", len(evicted), len(evicted)-pending, pending)
+	fmt.Printf("PDBs blocking the drain: %d\n", blocking)
+	return nil
+}
+
+// drainTargets resolves the nodes to drain from names or the label selector
+func drainTargets(nodes []v1.Node, names []string) (map[string]bool, error) {
+	drained := make(map[string]bool)
+
+	if drainSelector != "" {
+		selector, err := labels.Parse(drainSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", drainSelector, err)
+		}
+		for _, node := range nodes {
+			if selector.Matches(labels.Set(node.Labels)) {
+				drained[node.Name] = true
+			}
+		}
+		if len(drained) == 0 {
+			return nil, fmt.Errorf("no nodes match selector %q", drainSelector)
+		}
+		return drained, nil
+	}
+
+	known := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		known[node.Name] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return nil, fmt.Errorf("node %q not found", name)
+		}
+		drained[name] = true
+	}
+	return drained, nil
+}
+
+// evictablePods returns the pods a drain would evict, largest requests first
+func evictablePods(pods []v1.Pod, drained map[string]bool) []*evictedPod {
+	var evicted []*evictedPod
+	for i := range pods {
+		pod := &pods[i]
+		if !drained[pod.Spec.NodeName] || !isActivePod(pod) || isDaemonSetPod(pod) || isMirrorPod(pod) {
+			continue
+		}
+		evicted = append(evicted, &evictedPod{pod: pod, requests: podRequests(&pod.Spec)})
+	}
+
+	sort.SliceStable(evicted, func(i, j int) bool {
+		ci, cj := evicted[i].requests[v1.ResourceCPU], evicted[j].requests[v1.ResourceCPU]
+		if c := ci.Cmp(cj); c != 0 {
+			return c > 0
+		}
+		mi, mj := evicted[i].requests[v1.ResourceMemory], evicted[j].requests[v1.ResourceMemory]
+		return mi.Cmp(mj) > 0
+	})
+	return evicted
+}
+
+// placeEvictedPods assigns every evicted pod to the first remaining node it fits on
+func placeEvictedPods(evicted []*evictedPod, allocations map[string]*nodeAllocation) {
+	names := make([]string, 0, len(allocations))
+	for name := range allocations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, e := range evicted {
+		reasons := make(map[string]int)
+		for _, name := range names {
+			alloc := allocations[name]
+			blocking := placementReasons(alloc.node, &e.pod.Spec)
+			blocking = append(blocking, resourceReasons(alloc, e.requests)...)
+			if len(blocking) == 0 {
+				alloc.add(e.requests)
+				e.target = name
+				break
+			}
+			for _, r := range blocking {
+				reasons[r]++
+			}
+		}
+		if e.target == "" {
+			e.reason = summarizeReasons(reasons, len(names))
+		}
+	}
+}
+
+// summarizeReasons renders scheduler-style "3 insufficient cpu, 1 cordoned" messages
+func summarizeReasons(reasons map[string]int, nodes int) string {
+	if nodes == 0 {
+		return "no nodes left"
+	}
+	keys := make([]string, 0, len(reasons))
+	for r := range reasons {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if reasons[keys[i]] != reasons[keys[j]] {
+			return reasons[keys[i]] > reasons[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%d %s", reasons[k], k))
+	}
+	return fmt.Sprintf("0/%d nodes: %s", nodes, strings.Join(parts, ", "))
+}
+
+// pdbImpacts counts the evicted pods covered by each PodDisruptionBudget
+func pdbImpacts(pdbs []policyv1.PodDisruptionBudget, evicted []*evictedPod) []pdbImpact {
+	var impacts []pdbImpact
+	for i := range pdbs {
+		pdb := &pdbs[i]
+		// A nil selector matches no pods; in policy/v1 an empty one matches
+		// every pod in the namespace
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		count := 0
+		for _, e := range evicted {
+			if e.pod.Namespace == pdb.Namespace && selector.Matches(labels.Set(e.pod.Labels)) {
+				count++
+			}
+		}
+		if count > 0 {
+			impacts = append(impacts, pdbImpact{pdb: pdb, evicted: count})
+		}
+	}
+	return impacts
+}
+
+func isDaemonSetPod(pod *v1.Pod) bool {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return owner.Kind == "DaemonSet"
+	}
+	return false
+}
+
+func isMirrorPod(pod *v1.Pod) bool {
+	_, ok := pod.Annotations[v1.MirrorPodAnnotationKey]
+	return ok
+}
+
+func printEvictionTable(w *tabwriter.Writer, evicted []*evictedPod) {
+	fmt.Fprintln(w, strings.Join([]string{"NAMESPACE", "NAME", "NODE", "CPU REQ", "MEM REQ", "TARGET", "REASON"}, "\t"))
+	for _, e := range evicted {
+		target := e.target
+		if target == "" {
+			target = "<pending>"
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			e.pod.Namespace,
+			e.pod.Name,
+			e.pod.Spec.NodeName,
+			formatQuantity(e.requests[v1.ResourceCPU]),
+			formatQuantity(e.requests[v1.ResourceMemory]),
+			target,
+			e.reason,
+		}, "\t"))
+	}
+	w.Flush()
+}
+
+func printPDBTable(w *tabwriter.Writer, impacts []pdbImpact) {
+	fmt.Fprintln(w, strings.Join([]string{"NAMESPACE", "PDB", "EVICTED", "ALLOWED", "STATUS"}, "\t"))
+	for _, impact := range impacts {
+		status := "ok"
+		if int32(impact.evicted) > impact.pdb.Status.DisruptionsAllowed {
+			status = "blocks drain"
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			impact.pdb.Namespace,
+			impact.pdb.Name,
+			fmt.Sprintf("%d", impact.evicted),
+			fmt.Sprintf("%d", impact.pdb.Status.DisruptionsAllowed),
+			status,
+		}, "\t"))
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(drainSimCmd)
+	drainSimCmd.Flags().StringVarP(&drainSelector, "selector", "l", "", "Drain all nodes matching this label selector")
+}
@@ -0,0 +1,232 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var (
+	fitCPU      string
+	fitMemory   string
+	fitGPU      string
+	fitFile     string
+	fitReplicas int32
+)
+
+var fitCmd = &cobra.Command{
+	Use:   "fit",
+	Short: "Show which nodes could run a pod of the given shape",
+	Long: `Evaluate every node's free allocatable resources, taints, nodeSelector,
+required node affinity and max pods against a pod shape given either with
+--cpu/--memory/--gpu or as a Pod, Deployment, StatefulSet, ReplicaSet or Job
+manifest with -f.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFitCommand(cmd)
+	},
+}
+
+type fitResult struct {
+	alloc    *nodeAllocation
+	replicas int64
+	reasons  []string
+}
+
+func runFitCommand(cmd *cobra.Command) error {
+	spec, replicas, err := fitPodSpec()
+	if err != nil {
+		return err
+	}
+	// A replica count comes from --replicas or the manifest
+	requested := cmd.Flags().Changed("replicas") || fitFile != ""
+	if cmd.Flags().Changed("replicas") {
+		replicas = fitReplicas
+	}
+	requests := podRequests(spec)
+
+	// Get nodes and pods in parallel
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{Nodes: true, Pods: true})
+	if err != nil {
+		return err
+	}
+
+	allocations := buildNodeAllocations(state.Nodes, state.Pods)
+	results := evaluateFit(allocations, spec, requests)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	printFitTable(w, results)
+
+	var total int64
+	fittingNodes := 0
+	for _, r := range results {
+		if r.replicas > 0 {
+			total += r.replicas
+			fittingNodes++
+		}
+	}
+
+	fmt.Printf("\nPod shape: %s\n", formatRequests(requests))
+	fmt.Printf("Nodes that fit: %d of %d\n", fittingNodes, len(results))
+	switch {
+	case !requested:
+		fmt.Printf("Replicas that fit: %d\n", total)
+	case total >= int64(replicas):
+		fmt.Printf("Replicas that fit: %d (%d requested)\n", total, replicas)
+	default:
+		fmt.Printf("Replicas that fit: %d of %d requested (%d would stay Pending)\n", total, replicas, int64(replicas)-total)
+	}
+	return nil
+}
+
+// evaluateFit checks a pod spec against every node, sorted with the best fitting nodes first
+func evaluateFit(allocations map[string]*nodeAllocation, spec *v1.PodSpec, requests v1.ResourceList) []fitResult {
+	results := make([]fitResult, 0, len(allocations))
+	for _, alloc := range allocations {
+		reasons := placementReasons(alloc.node, spec)
+		reasons = append(reasons, resourceReasons(alloc, requests)...)
+
+		result := fitResult{alloc: alloc, reasons: reasons}
+		if len(reasons) == 0 {
+			result.replicas = replicasThatFit(alloc, requests)
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].replicas != results[j].replicas {
+			return results[i].replicas > results[j].replicas
+		}
+		return results[i].alloc.node.Name < results[j].alloc.node.Name
+	})
+	return results
+}
+
+func printFitTable(w *tabwriter.Writer, results []fitResult) {
+	fmt.Fprintln(w, strings.Join([]string{"NAME", "CPU FREE", "MEM FREE", "GPU FREE", "PODS FREE", "REPLICAS", "REASON"}, "\t"))
+	for _, r := range results {
+		reason := "fits"
+		if len(r.reasons) > 0 {
+			reason = strings.Join(r.reasons, ", ")
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			r.alloc.node.Name,
+			formatQuantity(r.alloc.free(v1.ResourceCPU)),
+			formatQuantity(r.alloc.free(v1.ResourceMemory)),
+			formatQuantity(r.alloc.free(gpuResource)),
+			fmt.Sprintf("%d", r.alloc.freePods()),
+			fmt.Sprintf("%d", r.replicas),
+			reason,
+		}, "\t"))
+	}
+	w.Flush()
+}
+
+func formatRequests(requests v1.ResourceList) string {
+	parts := make([]string, 0, len(schedulingResources))
+	for _, name := range schedulingResources {
+		if val, ok := requests[name]; ok && !val.IsZero() {
+			parts = append(parts, fmt.Sprintf("%s=%s", resourceLabel(name), formatQuantity(val)))
+		}
+	}
+	if len(parts) == 0 {
+		return "<no requests>"
+	}
+	return strings.Join(parts, " ")
+}
+
+// fitPodSpec builds the pod spec to evaluate from either the manifest or the resource flags
+func fitPodSpec() (*v1.PodSpec, int32, error) {
+	if fitFile != "" {
+		if fitCPU != "" || fitMemory != "" || fitGPU != "" {
+			return nil, 0, fmt.Errorf("-f cannot be combined with --cpu, --memory or --gpu")
+		}
+		return podSpecFromFile(fitFile)
+	}
+
+	requests := v1.ResourceList{}
+	for name, value := range map[v1.ResourceName]string{
+		v1.ResourceCPU:    fitCPU,
+		v1.ResourceMemory: fitMemory,
+		gpuResource:       fitGPU,
+	} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid %s quantity %q: %v", resourceLabel(name), value, err)
+		}
+		requests[name] = q
+	}
+	if len(requests) == 0 {
+		return nil, 0, fmt.Errorf("specify a pod shape with --cpu/--memory/--gpu or a manifest with -f")
+	}
+
+	spec := &v1.PodSpec{
+		Containers: []v1.Container{{
+			Name:      "fit",
+			Resources: v1.ResourceRequirements{Requests: requests},
+		}},
+	}
+	return spec, fitReplicas, nil
+}
+
+// podSpecFromFile reads a pod template and replica count from a manifest
+func podSpecFromFile(path string) (*v1.PodSpec, int32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decoding %s: %v", path, err)
+	}
+
+	replicasOrDefault := func(r *int32) int32 {
+		if r == nil {
+			return 1
+		}
+		return *r
+	}
+
+	switch o := obj.(type) {
+	case *v1.Pod:
+		return &o.Spec, 1, nil
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec, replicasOrDefault(o.Spec.Replicas), nil
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec, replicasOrDefault(o.Spec.Replicas), nil
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.Spec, replicasOrDefault(o.Spec.Replicas), nil
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec, replicasOrDefault(o.Spec.Parallelism), nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported kind %s in %s", obj.GetObjectKind().GroupVersionKind().Kind, path)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(fitCmd)
+	fitCmd.Flags().StringVar(&fitCPU, "cpu", "", "CPU request of the pod, e.g. 6 or 500m")
+	fitCmd.Flags().StringVar(&fitMemory, "memory", "", "Memory request of the pod, e.g. 24Gi")
+	fitCmd.Flags().StringVar(&fitGPU, "gpu", "", "GPU request of the pod")
+	fitCmd.Flags().StringVarP(&fitFile, "filename", "f", "", "Pod, Deployment, StatefulSet, ReplicaSet or Job manifest")
+	fitCmd.Flags().Int32Var(&fitReplicas, "replicas", 1, "Number of replicas to place (defaults to the manifest's replicas)")
+}
@@ -0,0 +1,319 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	hpaNamespace     string
+	hpaAllNamespaces bool
+)
+
+var hpaCmd = &cobra.Command{
+	Use:   "hpa",
+	Short: "HorizontalPodAutoscalers with the requests and usage of their targets",
+	Long: `List HorizontalPodAutoscalers (autoscaling/v2) with their replica range and
+metric targets, next to the requests and metrics-server usage of the pods of
+the target workload. Utilization targets are percentages of requests, so the
+usage column shows usage against requests the way the pods command does.
+
+The ISSUES column flags HPAs pinned at their maximum, HPAs whose target pods
+lack requests for a utilization-scaled resource and HPAs whose target
+Deployment, StatefulSet or ReplicaSet does not exist.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runHPACommand()
+	},
+}
+
+type hpaColumn struct {
+	header string
+	getter func(hpaInfo) string
+	// colored columns paint every cell, so their header is painted too
+	colored bool
+}
+
+type hpaInfo struct {
+	hpa    *autoscalingv2.HorizontalPodAutoscaler
+	target workloadRef
+	// pods, resources and usage are summed over the active pods of the target
+	pods      int
+	resources map[string]*resource.Quantity
+	cpuUsage  *resource.Quantity
+	memUsage  *resource.Quantity
+	issues    []string
+}
+
+var hpaColumns = []hpaColumn{
+	{header: "NAMESPACE", getter: func(h hpaInfo) string { return h.hpa.Namespace }},
+	{header: "NAME", getter: func(h hpaInfo) string { return h.hpa.Name }},
+	{header: "REFERENCE", getter: func(h hpaInfo) string { return h.target.kind + "/" + h.target.name }},
+	{header: "MIN", getter: func(h hpaInfo) string {
+		if h.hpa.Spec.MinReplicas == nil {
+			return "1"
+		}
+		return fmt.Sprintf("%d", *h.hpa.Spec.MinReplicas)
+	}},
+	{header: "CURRENT", getter: func(h hpaInfo) string { return fmt.Sprintf("%d", h.hpa.Status.CurrentReplicas) }},
+	{header: "MAX", getter: func(h hpaInfo) string { return fmt.Sprintf("%d", h.hpa.Spec.MaxReplicas) }},
+	{header: "TARGETS", getter: func(h hpaInfo) string { return hpaMetricTargets(h.hpa) }},
+	{header: "PODS", getter: func(h hpaInfo) string { return fmt.Sprintf("%d", h.pods) }},
+	{header: "CPU REQ", getter: func(h hpaInfo) string { return formatQuantity(*h.resources["cpuReq"]) }},
+	{header: "CPU USAGE (%)", getter: func(h hpaInfo) string { return usageCell(h.cpuUsage, h.resources["cpuReq"]) }, colored: true},
+	{header: "MEM REQ", getter: func(h hpaInfo) string { return formatQuantity(*h.resources["memReq"]) }},
+	{header: "MEM USAGE (%)", getter: func(h hpaInfo) string { return usageCell(h.memUsage, h.resources["memReq"]) }, colored: true},
+	{header: "ISSUES", getter: func(h hpaInfo) string {
+		if len(h.issues) == 0 {
+			return plainCell("-")
+		}
+		return paint(colorRed, strings.Join(h.issues, ", "))
+	}, colored: true},
+}
+
+func runHPACommand() error {
+	if err := validateDisplayFlags(); err != nil {
+		return err
+	}
+
+	hpaNs := hpaNamespace
+	if hpaAllNamespaces {
+		hpaNs = ""
+	} else if hpaNs == "" {
+		hpaNs = dataSource.DefaultNamespace()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{
+		Pods:                     true,
+		Namespace:                hpaNs,
+		PodMetrics:               true,
+		HorizontalPodAutoscalers: true,
+		Workloads:                true,
+	})
+	if err != nil {
+		return err
+	}
+	if state.MetricsErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Metrics not available: %v\n", state.MetricsErr)
+	}
+
+	hpas := getHPAInfoList(state)
+	sort.Slice(hpas, func(i, j int) bool {
+		if hpas[i].hpa.Namespace != hpas[j].hpa.Namespace {
+			return hpas[i].hpa.Namespace < hpas[j].hpa.Namespace
+		}
+		return hpas[i].hpa.Name < hpas[j].hpa.Name
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	headers := make([]string, len(hpaColumns))
+	for i, col := range hpaColumns {
+		headers[i] = col.header
+		if col.colored {
+			headers[i] = plainCell(col.header)
+		}
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, h := range hpas {
+		values := make([]string, len(hpaColumns))
+		for i, col := range hpaColumns {
+			values[i] = col.getter(h)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+	return nil
+}
+
+// getHPAInfoList correlates every HPA with the active pods of its target
+func getHPAInfoList(state *client.ClusterState) []hpaInfo {
+	workloads := make(map[workloadRef]bool, len(state.Workloads))
+	for _, workload := range state.Workloads {
+		workloads[workloadRef{namespace: workload.Namespace, kind: workload.Kind, name: workload.Name}] = true
+	}
+	podsByWorkload := make(map[workloadRef][]podInfo)
+	for _, pod := range getPodInfoList(state.Pods, state.PodMetrics) {
+		if pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+		podsByWorkload[pod.workload] = append(podsByWorkload[pod.workload], pod)
+	}
+
+	hpas := make([]hpaInfo, 0, len(state.HorizontalPodAutoscalers))
+	for i := range state.HorizontalPodAutoscalers {
+		hpa := &state.HorizontalPodAutoscalers[i]
+		info := hpaInfo{
+			hpa: hpa,
+			target: workloadRef{
+				namespace: hpa.Namespace,
+				kind:      hpa.Spec.ScaleTargetRef.Kind,
+				name:      hpa.Spec.ScaleTargetRef.Name,
+			},
+			resources: map[string]*resource.Quantity{
+				"cpuReq": resource.NewQuantity(0, resource.DecimalSI),
+				"memReq": resource.NewQuantity(0, resource.BinarySI),
+			},
+			cpuUsage: resource.NewQuantity(0, resource.DecimalSI),
+			memUsage: resource.NewQuantity(0, resource.BinarySI),
+		}
+
+		pods := podsByWorkload[info.target]
+		for _, pod := range pods {
+			info.pods++
+			addResources(info.resources, map[string]*resource.Quantity{"cpuReq": pod.resources["cpuReq"], "memReq": pod.resources["memReq"]})
+			info.cpuUsage.Add(*pod.cpuUsage)
+			info.memUsage.Add(*pod.memUsage)
+		}
+		if state.MetricsErr != nil {
+			info.cpuUsage, info.memUsage = nil, nil
+		}
+
+		if hpa.Status.CurrentReplicas >= hpa.Spec.MaxReplicas {
+			info.issues = append(info.issues, "at max")
+		}
+		for _, name := range hpaUnrequestedResources(hpa, pods) {
+			info.issues = append(info.issues, "no "+name+" requests")
+		}
+		switch info.target.kind {
+		case "Deployment", "StatefulSet", "ReplicaSet":
+			// Pods are also evidence, for dumps that hold pods but no workloads
+			if !workloads[info.target] && len(pods) == 0 {
+				info.issues = append(info.issues, "missing target")
+			}
+		}
+		hpas = append(hpas, info)
+	}
+	return hpas
+}
+
+// hpaUnrequestedResources lists the utilization-scaled resources that a
+// container of the target pods has no request for. The HPA controller cannot
+// compute utilization for those.
+func hpaUnrequestedResources(hpa *autoscalingv2.HorizontalPodAutoscaler, pods []podInfo) []string {
+	var missing []string
+	for _, metric := range hpa.Spec.Metrics {
+		var name v1.ResourceName
+		var container string
+		switch {
+		case metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil &&
+			metric.Resource.Target.Type == autoscalingv2.UtilizationMetricType:
+			name = metric.Resource.Name
+		case metric.Type == autoscalingv2.ContainerResourceMetricSourceType && metric.ContainerResource != nil &&
+			metric.ContainerResource.Target.Type == autoscalingv2.UtilizationMetricType:
+			name, container = metric.ContainerResource.Name, metric.ContainerResource.Container
+		default:
+			continue
+		}
+		if podsLackRequest(pods, name, container) {
+			missing = append(missing, string(name))
+		}
+	}
+	return missing
+}
+
+// podsLackRequest reports whether a container, or the named one, of any pod has no request for a resource
+func podsLackRequest(pods []podInfo, name v1.ResourceName, container string) bool {
+	for _, pod := range pods {
+		for _, c := range pod.object.Spec.Containers {
+			if container != "" && c.Name != container {
+				continue
+			}
+			if _, ok := c.Resources.Requests[name]; !ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hpaMetricTargets renders current/target per metric, like kubectl get hpa
+func hpaMetricTargets(hpa *autoscalingv2.HorizontalPodAutoscaler) string {
+	var targets []string
+	for i, metric := range hpa.Spec.Metrics {
+		var current *autoscalingv2.MetricStatus
+		if i < len(hpa.Status.CurrentMetrics) && hpa.Status.CurrentMetrics[i].Type == metric.Type {
+			current = &hpa.Status.CurrentMetrics[i]
+		}
+
+		switch metric.Type {
+		case autoscalingv2.ResourceMetricSourceType:
+			var value *autoscalingv2.MetricValueStatus
+			if current != nil && current.Resource != nil {
+				value = &current.Resource.Current
+			}
+			targets = append(targets, string(metric.Resource.Name)+": "+formatMetricValue(metric.Resource.Target, value))
+		case autoscalingv2.ContainerResourceMetricSourceType:
+			var value *autoscalingv2.MetricValueStatus
+			if current != nil && current.ContainerResource != nil {
+				value = &current.ContainerResource.Current
+			}
+			targets = append(targets, fmt.Sprintf("%s(%s): %s", metric.ContainerResource.Name, metric.ContainerResource.Container, formatMetricValue(metric.ContainerResource.Target, value)))
+		case autoscalingv2.PodsMetricSourceType:
+			var value *autoscalingv2.MetricValueStatus
+			if current != nil && current.Pods != nil {
+				value = &current.Pods.Current
+			}
+			targets = append(targets, metric.Pods.Metric.Name+": "+formatMetricValue(metric.Pods.Target, value))
+		case autoscalingv2.ObjectMetricSourceType:
+			var value *autoscalingv2.MetricValueStatus
+			if current != nil && current.Object != nil {
+				value = &current.Object.Current
+			}
+			targets = append(targets, metric.Object.Metric.Name+": "+formatMetricValue(metric.Object.Target, value))
+		case autoscalingv2.ExternalMetricSourceType:
+			var value *autoscalingv2.MetricValueStatus
+			if current != nil && current.External != nil {
+				value = &current.External.Current
+			}
+			targets = append(targets, metric.External.Metric.Name+": "+formatMetricValue(metric.External.Target, value))
+		}
+	}
+	if len(targets) == 0 {
+		return "<none>"
+	}
+	return strings.Join(targets, ", ")
+}
+
+// formatMetricValue renders current/target for the kind of target the metric has
+func formatMetricValue(target autoscalingv2.MetricTarget, current *autoscalingv2.MetricValueStatus) string {
+	value := "<unknown>"
+	switch {
+	case target.AverageUtilization != nil:
+		if current != nil && current.AverageUtilization != nil {
+			value = fmt.Sprintf("%d%%", *current.AverageUtilization)
+		}
+		return fmt.Sprintf("%s/%d%%", value, *target.AverageUtilization)
+	case target.AverageValue != nil:
+		if current != nil && current.AverageValue != nil {
+			value = formatQuantity(*current.AverageValue)
+		}
+		return value + "/" + formatQuantity(*target.AverageValue) + " (avg)"
+	case target.Value != nil:
+		if current != nil && current.Value != nil {
+			value = formatQuantity(*current.Value)
+		}
+		return value + "/" + formatQuantity(*target.Value)
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(hpaCmd)
+	hpaCmd.Flags().StringVarP(&hpaNamespace, "namespace", "n", "", "Show HPAs in the specified namespace")
+	hpaCmd.Flags().BoolVarP(&hpaAllNamespaces, "all-namespaces", "A", false, "Show HPAs from all namespaces")
+	addDisplayFlags(hpaCmd)
+}
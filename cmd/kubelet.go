@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	v1 "k8s.io/api/core/v1"
+)
+
+// showKubelet adds the reserved resources and kubelet config columns to the defaults
+var showKubelet bool
+
+// kubeletColumnIDs are added by --kubelet. All but the reserved gaps need configz.
+var kubeletColumnIDs = []string{"cpu-reserved", "mem-reserved", "kube-reserved", "system-reserved", "eviction-hard", "max-pods", "kubelet-drift"}
+
+// kubeletSettings are the kubelet config values compared within a node group
+var kubeletSettings = []struct {
+	name  string
+	value func(*client.KubeletConfig) string
+}{
+	{"kube-reserved", func(c *client.KubeletConfig) string { return formatSettingMap(c.KubeReserved, "=") }},
+	{"system-reserved", func(c *client.KubeletConfig) string { return formatSettingMap(c.SystemReserved, "=") }},
+	{"eviction-hard", func(c *client.KubeletConfig) string { return formatSettingMap(c.EvictionHard, "<") }},
+	{"max-pods", func(c *client.KubeletConfig) string { return fmt.Sprintf("%d", c.MaxPods) }},
+}
+
+var nodeKubeletColumns = append([]column{
+	{
+		id:     "cpu-reserved",
+		header: "CPU RESERVED",
+		getter: func(node nodeInfo) string { return reservedGap(node, v1.ResourceCPU) },
+	},
+	{
+		id:     "mem-reserved",
+		header: "MEM RESERVED",
+		getter: func(node nodeInfo) string { return reservedGap(node, v1.ResourceMemory) },
+	},
+}, kubeletSettingColumns()...)
+
+// reservedGap is capacity minus allocatable: kubelet reservations plus the hard eviction threshold
+func reservedGap(node nodeInfo, name v1.ResourceName) string {
+	if node.object == nil {
+		return ""
+	}
+	gap := node.object.Status.Capacity[name].DeepCopy()
+	gap.Sub(node.object.Status.Allocatable[name])
+	return formatQuantity(gap)
+}
+
+// kubeletSettingColumns show the kubelet config values, or <unknown> without
+// configz, followed by the drift column
+func kubeletSettingColumns() []column {
+	var cols []column
+	for _, setting := range kubeletSettings {
+		cols = append(cols, column{
+			id:     setting.name,
+			header: strings.ToUpper(strings.ReplaceAll(setting.name, "-", " ")),
+			getter: func(node nodeInfo) string {
+				if node.kubelet == nil {
+					if node.object == nil {
+						return ""
+					}
+					return "<unknown>"
+				}
+				return setting.value(node.kubelet)
+			},
+		})
+	}
+	return append(cols, nodeKubeletDriftColumn)
+}
+
+var nodeKubeletDriftColumn = column{
+	id:     "kubelet-drift",
+	header: "KUBELET DRIFT",
+	getter: func(node nodeInfo) string {
+		if len(node.kubeletDrift) == 0 {
+			return plainCell("-")
+		}
+		return paint(colorRed, strings.Join(node.kubeletDrift, ", "))
+	},
+	colored: true,
+}
+
+// needsKubeletConfig reports whether a column shows configz values
+func needsKubeletConfig(cols []column) bool {
+	for _, col := range cols {
+		for _, setting := range kubeletSettings {
+			if col.id == setting.name {
+				return true
+			}
+		}
+		if col.id == nodeKubeletDriftColumn.id {
+			return true
+		}
+	}
+	return false
+}
+
+// applyKubeletConfigs reads configz of every node, attaches it and flags the
+// settings in which a node differs from the majority of its node group
+func applyKubeletConfigs(ctx context.Context, cluster string, src client.Source, nodesList nodeInfoList) {
+	names := make([]string, len(nodesList))
+	for i, node := range nodesList {
+		names[i] = node.name
+	}
+	configs, err := client.FetchKubeletConfigs(ctx, src, names)
+	if err != nil {
+		kubeletWarning(cluster, err)
+	}
+
+	groups := make(map[string][]int)
+	for i := range nodesList {
+		nodesList[i].kubelet = configs[nodesList[i].name]
+		if nodesList[i].kubelet != nil {
+			group := nodeGroupName(nodesList[i].object)
+			groups[group] = append(groups[group], i)
+		}
+	}
+
+	for _, members := range groups {
+		for _, setting := range kubeletSettings {
+			majority, ok := majorityValue(nodesList, members, setting.value)
+			if !ok {
+				continue
+			}
+			for _, i := range members {
+				if setting.value(nodesList[i].kubelet) != majority {
+					nodesList[i].kubeletDrift = append(nodesList[i].kubeletDrift, setting.name)
+				}
+			}
+		}
+	}
+}
+
+// kubeletWarning reports nodes whose kubelet could not be read; the others are still shown
+func kubeletWarning(cluster string, err error) {
+	if cluster != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", cluster, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+}
+
+// majorityValue returns the value more than half of the members share, if any
+func majorityValue(nodesList nodeInfoList, members []int, value func(*client.KubeletConfig) string) (string, bool) {
+	counts := make(map[string]int)
+	for _, i := range members {
+		counts[value(nodesList[i].kubelet)]++
+	}
+	for v, count := range counts {
+		if count*2 > len(members) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// formatSettingMap renders a kubelet map setting as sorted key<sep>value pairs
+func formatSettingMap(settings map[string]string, sep string) string {
+	if len(settings) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(settings))
+	for key, value := range settings {
+		pairs = append(pairs, key+sep+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
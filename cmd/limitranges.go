@@ -0,0 +1,154 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	v1 "k8s.io/api/core/v1"
+)
+
+// limitRangerPrefix starts the annotation LimitRange admission writes on pods it changes
+const limitRangerPrefix = "LimitRanger plugin set: "
+
+// containerDefaults are the requests and limits LimitRange admission gives a
+// container that does not set them
+type containerDefaults struct {
+	requests v1.ResourceList
+	limits   v1.ResourceList
+}
+
+// requestCoverage tells which containers of a pod lack a CPU or memory request
+type requestCoverage struct {
+	// defaulted containers got their missing requests from LimitRange admission
+	defaulted []string
+	// unbounded containers have no CPU or memory request
+	unbounded []string
+	// predating containers have no request although a LimitRange of the
+	// namespace now sets one: the pod was created before the LimitRange
+	predating []string
+}
+
+// namespaceContainerDefaults merges the Container items of all LimitRanges per
+// namespace. Like admission, the first LimitRange setting a resource wins.
+func namespaceContainerDefaults(limitRanges []v1.LimitRange) map[string]containerDefaults {
+	defaults := make(map[string]containerDefaults)
+	for _, limitRange := range limitRanges {
+		nsDefaults, ok := defaults[limitRange.Namespace]
+		if !ok {
+			nsDefaults = containerDefaults{requests: v1.ResourceList{}, limits: v1.ResourceList{}}
+			defaults[limitRange.Namespace] = nsDefaults
+		}
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != v1.LimitTypeContainer {
+				continue
+			}
+			for name, q := range item.DefaultRequest {
+				if _, ok := nsDefaults.requests[name]; !ok {
+					nsDefaults.requests[name] = q
+				}
+			}
+			for name, q := range item.Default {
+				if _, ok := nsDefaults.limits[name]; !ok {
+					nsDefaults.limits[name] = q
+				}
+			}
+		}
+	}
+	return defaults
+}
+
+// podRequestCoverage classifies the containers of a pod by the requests
+// LimitRange admission set, as recorded in its annotation, and the CPU or
+// memory requests that are still missing
+func podRequestCoverage(pod *v1.Pod, defaults containerDefaults) requestCoverage {
+	var coverage requestCoverage
+	admitted := limitRangerRequests(pod)
+	for _, container := range pod.Spec.Containers {
+		unbounded, predating := false, false
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			if _, ok := container.Resources.Requests[name]; ok {
+				continue
+			}
+			if _, ok := defaults.requests[name]; ok {
+				predating = true
+			} else {
+				unbounded = true
+			}
+		}
+		switch {
+		case unbounded:
+			coverage.unbounded = append(coverage.unbounded, container.Name)
+		case predating:
+			coverage.predating = append(coverage.predating, container.Name)
+		case admitted[container.Name]:
+			coverage.defaulted = append(coverage.defaulted, container.Name)
+		}
+	}
+	return coverage
+}
+
+// limitRangerRequests returns the app containers whose requests LimitRange
+// admission set, from annotations like
+// "LimitRanger plugin set: cpu, memory request for container app; cpu limit for container app"
+func limitRangerRequests(pod *v1.Pod) map[string]bool {
+	value, ok := pod.Annotations[client.LimitRangerAnnotation]
+	if !ok {
+		return nil
+	}
+	containers := make(map[string]bool)
+	for _, entry := range strings.Split(strings.TrimPrefix(value, limitRangerPrefix), "; ") {
+		if _, name, ok := strings.Cut(entry, " request for container "); ok {
+			containers[name] = true
+		}
+	}
+	return containers
+}
+
+// requestCoverageByPod classifies every pod, keyed by namespace/name
+func requestCoverageByPod(pods []v1.Pod, defaults map[string]containerDefaults) map[string]requestCoverage {
+	coverage := make(map[string]requestCoverage, len(pods))
+	for i := range pods {
+		coverage[pods[i].Namespace+"/"+pods[i].Name] = podRequestCoverage(&pods[i], defaults[pods[i].Namespace])
+	}
+	return coverage
+}
+
+// setRequestCoverage attaches coverage computed by requestCoverageByPod to the pods
+func setRequestCoverage(podsList podInfoList, coverage map[string]requestCoverage) {
+	for i := range podsList {
+		podsList[i].requests = coverage[podsList[i].namespace+"/"+podsList[i].name]
+	}
+}
+
+// String renders the coverage for the REQUESTS column
+func (c requestCoverage) String() string {
+	var parts []string
+	if len(c.defaulted) > 0 {
+		parts = append(parts, "defaults: "+strings.Join(c.defaulted, ","))
+	}
+	if len(c.unbounded) > 0 {
+		parts = append(parts, "none: "+strings.Join(c.unbounded, ","))
+	}
+	if len(c.predating) > 0 {
+		parts = append(parts, "none, predates LimitRange: "+strings.Join(c.predating, ","))
+	}
+	if len(parts) == 0 {
+		return "set"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// limitRangesWarning reports LimitRanges that could not be read; pods missing
+// requests are then all shown as unbounded
+func limitRangesWarning(cluster string, err error) {
+	if cluster != "" {
+		fmt.Fprintf(os.Stderr, "Warning: Could not fetch LimitRanges from %s: %v\n", cluster, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: Could not fetch LimitRanges: %v\n", err)
+}
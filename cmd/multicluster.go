@@ -0,0 +1,186 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// multiClusterAnnotation marks commands that accept --contexts and --all-contexts
+const multiClusterAnnotation = "xtop/multi-cluster"
+
+// totalLabel fills the CLUSTER column of the cross-cluster total row
+const totalLabel = "TOTAL"
+
+var (
+	contextNames []string
+	allContexts  bool
+	// fetchTimeout bounds reading a single cluster, contextTimeout every cluster
+	// of a multi-cluster query
+	fetchTimeout   time.Duration
+	contextTimeout time.Duration
+)
+
+// clusterSource is one kubeconfig context of a multi-cluster query. err is set
+// when the client for the context could not even be created.
+type clusterSource struct {
+	name   string
+	source client.Source
+	err    error
+}
+
+// clusterSources is set when --contexts or --all-contexts is given
+var clusterSources []clusterSource
+
+type clusterResult[T any] struct {
+	cluster string
+	value   T
+	err     error
+}
+
+func multiCluster() bool {
+	return len(clusterSources) > 0
+}
+
+// initClusterSources creates a source per requested kubeconfig context
+func initClusterSources(cmd *cobra.Command) error {
+	if cmd.Annotations[multiClusterAnnotation] == "" {
+		return fmt.Errorf("%s does not support --contexts or --all-contexts", cmd.CommandPath())
+	}
+	if fromFile != "" || fromDir != "" {
+		return fmt.Errorf("--contexts and --all-contexts cannot be combined with --from-file or --from-dir")
+	}
+	if allContexts && len(contextNames) > 0 {
+		return fmt.Errorf("--contexts and --all-contexts cannot be combined")
+	}
+
+	names := contextNames
+	if allContexts {
+		var err error
+		names, err = client.Contexts()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no contexts found in kubeconfig")
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		source, err := client.NewContextSource(name)
+		clusterSources = append(clusterSources, clusterSource{name: name, source: source, err: err})
+	}
+	return nil
+}
+
+// queryClusters runs fetch against every cluster concurrently, each bounded by
+// --context-timeout. Without --contexts it runs once against dataSource with an
+// empty cluster name, bounded by --timeout.
+func queryClusters[T any](fetch func(ctx context.Context, cluster string, src client.Source) (T, error)) []clusterResult[T] {
+	if !multiCluster() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		value, err := fetch(ctx, "", dataSource)
+		return []clusterResult[T]{{value: value, err: err}}
+	}
+
+	results := make([]clusterResult[T], len(clusterSources))
+	var wg sync.WaitGroup
+	for i, cs := range clusterSources {
+		results[i].cluster = cs.name
+		if cs.err != nil {
+			results[i].err = cs.err
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cs clusterSource) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+			defer cancel()
+			results[i].value, results[i].err = fetch(ctx, cs.name, cs.source)
+		}(i, cs)
+	}
+	wg.Wait()
+	return results
+}
+
+// succeededClusters returns the results that have no error. A single cluster
+// failing is returned as the error; in multi-cluster mode failures are only
+// fatal when no cluster answered.
+func succeededClusters[T any](results []clusterResult[T]) ([]clusterResult[T], error) {
+	if !multiCluster() {
+		return results, results[0].err
+	}
+
+	var succeeded []clusterResult[T]
+	for _, result := range results {
+		if result.err == nil {
+			succeeded = append(succeeded, result)
+		}
+	}
+	if len(succeeded) == 0 {
+		printFailedClusters(results)
+		return nil, fmt.Errorf("all %d clusters failed", len(results))
+	}
+	return succeeded, nil
+}
+
+// printFailedClusters lists the clusters that could not be queried on stderr
+func printFailedClusters[T any](results []clusterResult[T]) {
+	first := true
+	for _, result := range results {
+		if result.err == nil {
+			continue
+		}
+		if first {
+			fmt.Fprintln(os.Stderr, "\nFailed clusters:")
+			first = false
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", result.cluster, result.err)
+	}
+}
+
+// metricsWarning reports that metrics-server could not be read, naming the cluster when there is one
+func metricsWarning(cluster string, err error) {
+	if cluster != "" {
+		fmt.Printf("Warning: Could not fetch metrics from %s: %v\n", cluster, err)
+		return
+	}
+	fmt.Printf("Warning: Could not fetch metrics: %v\n", err)
+}
+
+// addResources adds every quantity in src to dst, creating missing keys
+func addResources(dst, src map[string]*resource.Quantity) {
+	for key, q := range src {
+		if q == nil {
+			continue
+		}
+		if dst[key] == nil {
+			copied := q.DeepCopy()
+			dst[key] = &copied
+			continue
+		}
+		dst[key].Add(*q)
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringSliceVar(&contextNames, "contexts", nil, "comma separated kubeconfig contexts to query concurrently (nodes, pods, namespaces and cluster)")
+	rootCmd.PersistentFlags().BoolVar(&allContexts, "all-contexts", false, "query every kubeconfig context concurrently (nodes, pods, namespaces and cluster)")
+	rootCmd.PersistentFlags().DurationVar(&fetchTimeout, "timeout", 60*time.Second, "time limit for reading cluster state")
+	rootCmd.PersistentFlags().DurationVar(&contextTimeout, "context-timeout", 30*time.Second, "per-cluster timeout when querying several contexts")
+}
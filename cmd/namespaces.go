@@ -0,0 +1,332 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	namespaceSortBy string
+)
+
+var namespacesCmd = &cobra.Command{
+	Use:         "namespaces",
+	Aliases:     []string{"ns"},
+	Short:       "Top namespaces",
+	Annotations: map[string]string{multiClusterAnnotation: "true"},
+	Long: `Sum the requests, limits and usage of the pods of every namespace.
+
+Requests already include the defaults LimitRange admission gave containers
+without them when their pod was created. DEFAULTED counts those containers,
+NO REQUESTS the containers still missing a CPU or memory request and
+PREDATE LIMITRANGE the ones missing a request a LimitRange now sets by default.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNamespacesCommand()
+	},
+}
+
+type namespaceColumn struct {
+	header string
+	getter func(namespaceInfo) string
+}
+
+type namespaceInfo struct {
+	cluster    string
+	name       string
+	pods       int
+	resources  map[string]*resource.Quantity
+	hourlyCost float64
+	// defaulted counts containers whose requests LimitRange admission set,
+	// unbounded the containers still missing a CPU or memory request and
+	// predating the ones created before a LimitRange that now sets it
+	defaulted  int
+	unbounded  int
+	predating  int
+	bestEffort int
+}
+
+// namespaceColumns start every namespace table, see namespaceTableColumns
+var namespaceColumns = []namespaceColumn{
+	{
+		header: "NAME",
+		getter: func(ns namespaceInfo) string {
+			return ns.name
+		},
+	},
+	{
+		header: "PODS",
+		getter: func(ns namespaceInfo) string {
+			return fmt.Sprintf("%d", ns.pods)
+		},
+	},
+}
+
+var namespaceRequestsColumns = []namespaceColumn{
+	{
+		header: "DEFAULTED",
+		getter: func(ns namespaceInfo) string {
+			return fmt.Sprintf("%d", ns.defaulted)
+		},
+	},
+	{
+		header: "NO REQUESTS",
+		getter: func(ns namespaceInfo) string {
+			return fmt.Sprintf("%d", ns.unbounded)
+		},
+	},
+	{
+		header: "PREDATE LIMITRANGE",
+		getter: func(ns namespaceInfo) string {
+			return fmt.Sprintf("%d", ns.predating)
+		},
+	},
+	{
+		header: "BESTEFFORT",
+		getter: func(ns namespaceInfo) string {
+			return fmt.Sprintf("%d", ns.bestEffort)
+		},
+	},
+}
+
+var namespaceCostColumns = []namespaceColumn{
+	{
+		header: "COST/HR",
+		getter: func(ns namespaceInfo) string {
+			return formatCost(ns.hourlyCost)
+		},
+	},
+	{
+		header: "COST/MONTH",
+		getter: func(ns namespaceInfo) string {
+			return formatMonthlyCost(ns.hourlyCost)
+		},
+	},
+}
+
+var namespaceClusterColumn = namespaceColumn{
+	header: "CLUSTER",
+	getter: func(ns namespaceInfo) string {
+		return ns.cluster
+	},
+}
+
+type namespaceInfoList []namespaceInfo
+
+func (n namespaceInfoList) Len() int      { return len(n) }
+func (n namespaceInfoList) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n namespaceInfoList) Less(i, j int) bool {
+	sortMap := map[string]string{
+		"cpu-req":   "cpuReq",
+		"cpu-limit": "cpuLimit",
+		"cpu-usage": "cpuUsage",
+		"mem-req":   "memReq",
+		"mem-limit": "memLimit",
+		"mem-usage": "memUsage",
+	}
+
+	if resourceKey, ok := sortMap[namespaceSortBy]; ok {
+		return n[i].resources[resourceKey].Cmp(*n[j].resources[resourceKey]) < 0
+	}
+	if namespaceSortBy == "cost" {
+		return n[i].hourlyCost < n[j].hourlyCost
+	}
+	if n[i].name == n[j].name {
+		return n[i].cluster < n[j].cluster
+	}
+	return n[i].name < n[j].name
+}
+
+func runNamespacesCommand() error {
+	prices, err := validateCostFlags()
+	if err != nil {
+		return err
+	}
+
+	// Get namespaces from every cluster
+	results := queryClusters(func(ctx context.Context, cluster string, src client.Source) (namespaceInfoList, error) {
+		return collectNamespaces(ctx, cluster, src, prices)
+	})
+	succeeded, err := succeededClusters(results)
+	if err != nil {
+		return err
+	}
+	var namespacesList namespaceInfoList
+	for _, result := range succeeded {
+		namespacesList = append(namespacesList, result.value...)
+	}
+
+	// Sort the slice
+	sort.Sort(namespacesList)
+
+	if multiCluster() && len(namespacesList) > 0 {
+		namespacesList = append(namespacesList, namespacesTotal(namespacesList))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	printNamespaceTable(w, namespaceTableColumns(prices != nil), namespacesList)
+	if multiCluster() {
+		printFailedClusters(results)
+	}
+	return nil
+}
+
+// collectNamespaces aggregates the namespaces of one cluster, priced when a price table is given
+func collectNamespaces(ctx context.Context, cluster string, src client.Source, prices *priceTable) (namespaceInfoList, error) {
+	// Get pods and LimitRanges in all namespaces, metrics and, for pricing, nodes in parallel
+	state, err := client.FetchState(ctx, src, client.FetchRequest{
+		Nodes:       prices != nil,
+		Pods:        true,
+		PodMetrics:  true,
+		LimitRanges: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if state.MetricsErr != nil {
+		metricsWarning(cluster, state.MetricsErr)
+	}
+	if state.LimitRangesErr != nil {
+		limitRangesWarning(cluster, state.LimitRangesErr)
+	}
+
+	podsList := getPodInfoList(state.Pods, state.PodMetrics)
+	setRequestCoverage(podsList, requestCoverageByPod(state.Pods, namespaceContainerDefaults(state.LimitRanges)))
+
+	// Price pods before they are summed per namespace
+	if prices != nil {
+		applyCosts(prices, getNodeInfoList(state.Nodes, nil), podsList)
+	}
+
+	namespacesList := getNamespaceInfoList(podsList)
+	for i := range namespacesList {
+		namespacesList[i].cluster = cluster
+	}
+	return namespacesList, nil
+}
+
+// namespacesTotal sums all namespaces into the cross-cluster total row
+func namespacesTotal(namespacesList namespaceInfoList) namespaceInfo {
+	total := namespaceInfo{cluster: totalLabel, resources: make(map[string]*resource.Quantity)}
+	for _, ns := range namespacesList {
+		total.pods += ns.pods
+		total.hourlyCost += ns.hourlyCost
+		total.defaulted += ns.defaulted
+		total.unbounded += ns.unbounded
+		total.predating += ns.predating
+		total.bestEffort += ns.bestEffort
+		addResources(total.resources, ns.resources)
+	}
+	return total
+}
+
+// getNamespaceInfoList sums the requests, limits and usage of active pods per namespace
+func getNamespaceInfoList(podsList podInfoList) namespaceInfoList {
+	namespaces := make(map[string]*namespaceInfo)
+	for _, pod := range podsList {
+		if pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+
+		ns, ok := namespaces[pod.namespace]
+		if !ok {
+			ns = &namespaceInfo{
+				name: pod.namespace,
+				resources: map[string]*resource.Quantity{
+					"cpuReq":   resource.NewQuantity(0, resource.DecimalSI),
+					"cpuLimit": resource.NewQuantity(0, resource.DecimalSI),
+					"cpuUsage": resource.NewQuantity(0, resource.DecimalSI),
+					"memReq":   resource.NewQuantity(0, resource.BinarySI),
+					"memLimit": resource.NewQuantity(0, resource.BinarySI),
+					"memUsage": resource.NewQuantity(0, resource.BinarySI),
+				},
+			}
+			namespaces[pod.namespace] = ns
+		}
+
+		ns.pods++
+		ns.hourlyCost += pod.hourlyCost
+		ns.defaulted += len(pod.requests.defaulted)
+		ns.unbounded += len(pod.requests.unbounded)
+		ns.predating += len(pod.requests.predating)
+		if pod.qosClass == string(v1.PodQOSBestEffort) {
+			ns.bestEffort++
+		}
+		for _, key := range []string{"cpuReq", "cpuLimit", "memReq", "memLimit"} {
+			if q := pod.resources[key]; q != nil {
+				ns.resources[key].Add(*q)
+			}
+		}
+		if pod.cpuUsage != nil {
+			ns.resources["cpuUsage"].Add(*pod.cpuUsage)
+		}
+		if pod.memUsage != nil {
+			ns.resources["memUsage"].Add(*pod.memUsage)
+		}
+	}
+
+	namespacesList := make(namespaceInfoList, 0, len(namespaces))
+	for _, ns := range namespaces {
+		namespacesList = append(namespacesList, *ns)
+	}
+	return namespacesList
+}
+
+// namespaceTableColumns builds the columns of one run: CLUSTER when querying
+// several clusters and the cost columns when a price table is given
+func namespaceTableColumns(priced bool) []namespaceColumn {
+	var cols []namespaceColumn
+	if multiCluster() {
+		cols = append(cols, namespaceClusterColumn)
+	}
+	cols = append(cols, namespaceColumns...)
+	for _, key := range []string{"cpuReq", "cpuLimit", "cpuUsage", "memReq", "memLimit", "memUsage"} {
+		cols = append(cols, namespaceColumn{
+			header: toColumnName(key),
+			getter: func(ns namespaceInfo) string {
+				return formatQuantity(*ns.resources[key])
+			},
+		})
+	}
+	cols = append(cols, namespaceRequestsColumns...)
+	if priced {
+		cols = append(cols, namespaceCostColumns...)
+	}
+	return cols
+}
+
+func printNamespaceTable(w *tabwriter.Writer, cols []namespaceColumn, namespacesList namespaceInfoList) {
+	// Print headers
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = col.header
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	// Print rows
+	for _, ns := range namespacesList {
+		values := make([]string, len(cols))
+		for i, col := range cols {
+			values[i] = col.getter(ns)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(namespacesCmd)
+	namespacesCmd.Flags().StringVar(&namespaceSortBy, "sort-by", "name", "Sort namespaces by: name, cpu-req, cpu-limit, cpu-usage, mem-req, mem-limit, mem-usage, cost")
+	addCostFlags(namespacesCmd)
+}
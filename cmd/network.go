@@ -0,0 +1,228 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+)
+
+// sampleInterval separates the two kubelet samples network rates and CPU
+// throttling are computed from
+var sampleInterval time.Duration
+
+// netColumnIDs are the network throughput columns of nodes and pods
+var netColumnIDs = []string{"net-rx", "net-tx"}
+
+// netRate is network throughput in bytes per second
+type netRate struct {
+	rx float64
+	tx float64
+}
+
+var nodeNetColumns = []column{
+	{
+		id:     "net-rx",
+		header: "NET RX",
+		getter: func(node nodeInfo) string { return formatNetRate(node.net, "net-rx") },
+	},
+	{
+		id:     "net-tx",
+		header: "NET TX",
+		getter: func(node nodeInfo) string { return formatNetRate(node.net, "net-tx") },
+	},
+}
+
+var podNetColumns = []podColumn{
+	{
+		id:     "net-rx",
+		header: "NET RX",
+		getter: func(pod podInfo) string { return formatNetRate(pod.net, "net-rx") },
+	},
+	{
+		id:     "net-tx",
+		header: "NET TX",
+		getter: func(pod podInfo) string { return formatNetRate(pod.net, "net-tx") },
+	},
+}
+
+// isNetSort reports whether a --sort-by value sorts by network throughput
+func isNetSort(sortKey string) bool {
+	return sortKey == "net-rx" || sortKey == "net-tx"
+}
+
+// value returns the rate a net column or sort key shows, -1 when unknown so unknown rates sort first
+func (r *netRate) value(key string) float64 {
+	switch {
+	case r == nil:
+		return -1
+	case key == "net-tx":
+		return r.tx
+	default:
+		return r.rx
+	}
+}
+
+// sampleKubeletStats reads the kubelet stats of the nodes, warning about the ones that fail
+func sampleKubeletStats(ctx context.Context, cluster string, src client.Source, nodeNames []string) map[string]*client.StatsSummary {
+	summaries, err := client.FetchStatsSummaries(ctx, src, nodeNames)
+	if err != nil {
+		kubeletWarning(cluster, err)
+	}
+	return summaries
+}
+
+// kubeletSample holds the kubelet endpoints read at one point in time, by node name
+type kubeletSample struct {
+	stats    map[string]*client.StatsSummary
+	cadvisor map[string]*client.CadvisorStats
+}
+
+// sampleKubelet reads the kubelet stats and the cAdvisor metrics of the nodes, as asked for
+func sampleKubelet(ctx context.Context, cluster string, src client.Source, nodeNames []string, stats, cadvisor bool) kubeletSample {
+	var sample kubeletSample
+	if stats {
+		sample.stats = sampleKubeletStats(ctx, cluster, src, nodeNames)
+	}
+	if cadvisor {
+		sample.cadvisor = sampleCadvisor(ctx, cluster, src, nodeNames)
+	}
+	return sample
+}
+
+// sampleKubeletTwice takes two samples sampleInterval apart, for rates
+func sampleKubeletTwice(ctx context.Context, cluster string, src client.Source, nodeNames []string, stats, cadvisor bool) (kubeletSample, kubeletSample, error) {
+	first := sampleKubelet(ctx, cluster, src, nodeNames, stats, cadvisor)
+	select {
+	case <-ctx.Done():
+		return kubeletSample{}, kubeletSample{}, ctx.Err()
+	case <-time.After(sampleInterval):
+	}
+	return first, sampleKubelet(ctx, cluster, src, nodeNames, stats, cadvisor), nil
+}
+
+// measureNetwork takes two samples sampleInterval apart and returns the rates of
+// nodes by name and of pods by namespace/name
+func measureNetwork(ctx context.Context, cluster string, src client.Source, nodeNames []string) (map[string]netRate, map[string]netRate, error) {
+	first, second, err := sampleKubeletTwice(ctx, cluster, src, nodeNames, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeRates, podRates := networkRates(first.stats, second.stats)
+	return nodeRates, podRates, nil
+}
+
+// networkRates computes rates between two samples over the sample times the
+// kubelet reports, which only advance when it refreshes its stats
+func networkRates(prev, cur map[string]*client.StatsSummary) (map[string]netRate, map[string]netRate) {
+	nodeRates := make(map[string]netRate)
+	podRates := make(map[string]netRate)
+	for name, summary := range cur {
+		before, ok := prev[name]
+		if !ok {
+			continue
+		}
+		if rate, ok := rateBetween(before.Node.Network, summary.Node.Network); ok {
+			nodeRates[name] = rate
+		}
+
+		previousPods := make(map[string]client.PodStats, len(before.Pods))
+		for _, pod := range before.Pods {
+			previousPods[pod.PodRef.Namespace+"/"+pod.PodRef.Name] = pod
+		}
+		for _, pod := range summary.Pods {
+			key := pod.PodRef.Namespace + "/" + pod.PodRef.Name
+			previous, ok := previousPods[key]
+			// A pod recreated under the same name starts its counters anew
+			if !ok || previous.PodRef.UID != pod.PodRef.UID {
+				continue
+			}
+			if rate, ok := rateBetween(previous.Network, pod.Network); ok {
+				podRates[key] = rate
+			}
+		}
+	}
+	return nodeRates, podRates
+}
+
+// rateBetween returns the throughput between two counter samples, if the
+// samples are apart in time and the counters were not reset in between
+func rateBetween(prev, cur *client.NetworkStats) (netRate, bool) {
+	if prev == nil || cur == nil || prev.RxBytes == nil || prev.TxBytes == nil || cur.RxBytes == nil || cur.TxBytes == nil {
+		return netRate{}, false
+	}
+	seconds := cur.Time.Sub(prev.Time.Time).Seconds()
+	if seconds <= 0 || *cur.RxBytes < *prev.RxBytes || *cur.TxBytes < *prev.TxBytes {
+		return netRate{}, false
+	}
+	return netRate{
+		rx: float64(*cur.RxBytes-*prev.RxBytes) / seconds,
+		tx: float64(*cur.TxBytes-*prev.TxBytes) / seconds,
+	}, true
+}
+
+// applyNodeNetRates attaches rates to the nodes they were measured on
+func applyNodeNetRates(nodesList nodeInfoList, rates map[string]netRate) {
+	for i := range nodesList {
+		if rate, ok := rates[nodesList[i].name]; ok {
+			nodesList[i].net = &rate
+		}
+	}
+}
+
+// applyPodNetRates attaches rates to the pods they were measured for
+func applyPodNetRates(podsList podInfoList, rates map[string]netRate) {
+	for i := range podsList {
+		if rate, ok := rates[podsList[i].namespace+"/"+podsList[i].name]; ok {
+			podsList[i].net = &rate
+		}
+	}
+}
+
+// addNetRate adds rate to the sum, for total rows
+func addNetRate(sum **netRate, rate *netRate) {
+	if rate == nil {
+		return
+	}
+	if *sum == nil {
+		*sum = &netRate{}
+	}
+	(*sum).rx += rate.rx
+	(*sum).tx += rate.tx
+}
+
+func formatNetRate(rate *netRate, key string) string {
+	if rate == nil {
+		return "<none>"
+	}
+	return formatByteRate(rate.value(key))
+}
+
+// formatByteRate renders bytes per second with binary units, e.g. 1.5MiB/s
+func formatByteRate(bytes float64) string {
+	return formatBytes(bytes) + "/s"
+}
+
+// formatBytes renders a size with binary units, e.g. 1.5GiB
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f%s", bytes, units[unit])
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", bytes), ".0") + units[unit]
+}
+
+func addSampleIntervalFlag(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&sampleInterval, "sample-interval", 10*time.Second, "Time between the two kubelet samples network rates and CPU throttling are computed from; the kubelet refreshes its stats about every 10s")
+}
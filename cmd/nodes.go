@@ -6,16 +6,18 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 	"text/tabwriter"
 
 	client "github.com/akomic/kubectl-xtop/client"
-	v1 "k8s.io/api/core/v1"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 )
 
 // nodesCmd represents the nodes command
@@ -24,21 +26,39 @@ var (
 )
 
 var nodesCmd = &cobra.Command{
-	Use:   "nodes",
-	Short: "Top nodes",
-	Run: func(cmd *cobra.Command, args []string) {
-		runNodesCommand()
+	Use:         "nodes",
+	Short:       "Top nodes",
+	Annotations: map[string]string{multiClusterAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodesCommand()
 	},
 }
 
 type column struct {
+	// id selects the column with --columns
+	id     string
 	header string
 	getter func(nodeInfo) string
+	// colored columns paint every cell, so their header is painted too
+	colored bool
 }
 
 type nodeInfo struct {
+	cluster   string
 	name      string
+	meta      map[string]string
 	resources map[string]*resource.Quantity
+	cost      *nodeCost
+	// kubelet is the node's configz, nil when not read; kubeletDrift lists the
+	// settings that differ from the majority of the node group
+	kubelet      *client.KubeletConfig
+	kubeletDrift []string
+	// net is the measured network throughput, nil when not measured
+	net *netRate
+	// pods are the active pods on the node, only collected for --pods
+	pods podInfoList
+	// object is the Node read from the cluster, for custom columns
+	object *v1.Node
 }
 
 func toColumnName(key string) string {
@@ -52,7 +72,70 @@ func toColumnName(key string) string {
 	return strings.ToUpper(key) // fallback for keys without camelCase
 }
 
-var columns []column
+// nodeColumnCatalog holds every built-in node column
+var nodeColumnCatalog []column
+
+// defaultNodeColumns are shown when --columns is not given
+var defaultNodeColumns = []string{"name", "cpu-capacity", "cpu-req", "cpu-limit", "mem-capacity", "mem-req", "mem-limit"}
+
+// overcommitColumnIDs are added to the defaults by --overcommit
+var overcommitColumnIDs = []string{"cpu-limit-alloc", "mem-limit-alloc", "burst-risk"}
+
+// showOvercommit adds the limit overcommit and burst risk columns to the defaults
+var showOvercommit bool
+
+var nodeCostColumns = []column{
+	{
+		id:     "cost-hr",
+		header: "COST/HR",
+		getter: func(node nodeInfo) string {
+			if node.cost == nil {
+				return "-"
+			}
+			return formatCost(node.cost.hourly)
+		},
+	},
+	{
+		id:     "cost-month",
+		header: "COST/MONTH",
+		getter: func(node nodeInfo) string {
+			if node.cost == nil {
+				return "-"
+			}
+			return formatMonthlyCost(node.cost.hourly)
+		},
+	},
+	{
+		id:     "idle-hr",
+		header: "IDLE/HR",
+		getter: func(node nodeInfo) string {
+			if node.cost == nil {
+				return "-"
+			}
+			return formatCost(node.cost.idle())
+		},
+	},
+}
+
+var nodeClusterColumn = column{
+	id:     "cluster",
+	header: "CLUSTER",
+	getter: func(node nodeInfo) string {
+		return node.cluster
+	},
+}
+
+// nodeExtras selects the optional data collectNodes reads besides nodes and pods
+type nodeExtras struct {
+	// usage is node usage from metrics-server
+	usage bool
+	// kubelet is the configz of every node
+	kubelet bool
+	// network is throughput measured from two kubelet stats samples
+	network bool
+	// pods are the pods of every node with their usage, for the tree of --pods
+	pods bool
+}
 
 type nodeInfoList []nodeInfo
 
@@ -61,57 +144,294 @@ func (n nodeInfoList) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
 func (n nodeInfoList) Less(i, j int) bool {
 	sortMap := map[string]string{
 		"cpu-req":   "cpuReq",
-		"cpu-limit": "cpuLimit", 
+		"cpu-limit": "cpuLimit",
 		"mem-req":   "memReq",
 		"mem-limit": "memLimit",
 	}
-	
+
 	if resourceKey, ok := sortMap[sortBy]; ok {
 		return n[i].resources[resourceKey].Cmp(*n[j].resources[resourceKey]) < 0
 	}
+	if isNetSort(sortBy) {
+		rateI, rateJ := n[i].net.value(sortBy), n[j].net.value(sortBy)
+		if rateI != rateJ {
+			return rateI < rateJ
+		}
+	}
+	if sortBy == "burst-risk" {
+		// Nodes without usage sort first
+		riskI, _ := n[i].burstRisk()
+		riskJ, _ := n[j].burstRisk()
+		if riskI != riskJ {
+			return riskI < riskJ
+		}
+	}
+	if n[i].name == n[j].name {
+		return n[i].cluster < n[j].cluster
+	}
 	return n[i].name < n[j].name
 }
 
-func runNodesCommand() {
-	// Initialize maps outside loop
-	nodesMeta := make(map[string]map[string]string)
-	nodesResources := make(map[string]map[string]*resource.Quantity)
+func runNodesCommand() error {
+	prices, err := validateCostFlags()
+	if err != nil {
+		return err
+	}
+	if err := validateDisplayFlags(); err != nil {
+		return err
+	}
+	custom, err := parseOutputFormat()
+	if err != nil {
+		return err
+	}
+	cols, err := nodeTableColumns(prices != nil, custom)
+	if err != nil {
+		return err
+	}
 
-	// Get nodes info
-	nodes, err := client.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	// Get nodes info from every cluster
+	extras := nodeExtras{
+		usage: slices.ContainsFunc(cols, func(col column) bool {
+			return col.id == "burst-risk" || slices.Contains(nodeUsageColumnIDs, col.id)
+		}) || sortBy == "burst-risk",
+		kubelet: needsKubeletConfig(cols),
+		network: slices.ContainsFunc(cols, func(col column) bool { return slices.Contains(netColumnIDs, col.id) }) || isNetSort(sortBy),
+		pods:    showNodePods,
+	}
+	results := queryClusters(func(ctx context.Context, cluster string, src client.Source) (nodeInfoList, error) {
+		return collectNodes(ctx, cluster, src, prices, extras)
+	})
+	succeeded, err := succeededClusters(results)
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+	var nodesList nodeInfoList
+	for _, result := range succeeded {
+		nodesList = append(nodesList, result.value...)
+	}
+
+	// Sort the slice
+	sort.Sort(nodesList)
+
+	if multiCluster() && len(nodesList) > 0 {
+		nodesList = append(nodesList, nodesTotal(nodesList))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	if showNodePods {
+		printNodeTree(w, cols, nodesList)
+	} else {
+		printTable(w, cols, nodesList)
+	}
+	if multiCluster() {
+		printFailedClusters(results)
+	}
+	return nil
+}
+
+// nodeTableColumns picks the columns of this run: --columns or the defaults
+// with overcommit columns for --overcommit, usage columns for --pods, kubelet columns for --kubelet and cost columns
+// when priced, then custom
+// columns, and CLUSTER first when querying several clusters
+func nodeTableColumns(priced bool, custom []customColumn) ([]column, error) {
+	defaults := defaultNodeColumns
+	if showOvercommit {
+		defaults = append(append([]string{}, defaults...), overcommitColumnIDs...)
+	} else if sortBy == "burst-risk" {
+		defaults = append(append([]string{}, defaults...), "burst-risk")
+	}
+	if isNetSort(sortBy) {
+		defaults = append(append([]string{}, defaults...), netColumnIDs...)
+	}
+	if showNodePods {
+		defaults = append(append([]string{}, defaults...), nodeUsageColumnIDs...)
+	}
+	if showKubelet {
+		defaults = append(append([]string{}, defaults...), kubeletColumnIDs...)
+	}
+	if priced {
+		defaults = append(append([]string{}, defaults...), costColumnIDs...)
+	}
+	ids := requestedColumnIDs(defaults, custom)
+	if err := validateCostColumns(ids, priced); err != nil {
+		return nil, err
+	}
+	cols, err := selectColumns(nodeColumnCatalog, func(col column) string { return col.id }, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range custom {
+		cols = append(cols, column{
+			header: c.header,
+			getter: func(node nodeInfo) string {
+				if node.object == nil {
+					return ""
+				}
+				return c.value(node.object)
+			},
+		})
 	}
 
+	if multiCluster() && !slices.Contains(ids, nodeClusterColumn.id) {
+		cols = append([]column{nodeClusterColumn}, cols...)
+	}
+	return cols, nil
+}
+
+// collectNodes aggregates the nodes of one cluster, priced when a price table is
+// given and with the extras asked for
+func collectNodes(ctx context.Context, cluster string, src client.Source, prices *priceTable, extras nodeExtras) (nodeInfoList, error) {
+	// Get nodes, pods and, when pricing by usage or asked for, metrics in parallel
+	state, err := client.FetchState(ctx, src, client.FetchRequest{
+		Nodes:       true,
+		Pods:        true,
+		PodMetrics:  (prices != nil && costMode == "usage") || extras.pods,
+		NodeMetrics: extras.usage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if state.MetricsErr != nil {
+		metricsWarning(cluster, state.MetricsErr)
+	}
+
+	nodesList := getNodeInfoList(state.Nodes, state.Pods)
+	if prices != nil || extras.pods {
+		podsList := getPodInfoList(state.Pods, state.PodMetrics)
+		if prices != nil {
+			applyCosts(prices, nodesList, podsList)
+		}
+		if extras.pods {
+			attachNodePods(nodesList, podsList)
+		}
+	}
+	applyNodeUsage(nodesList, state.NodeMetrics)
+	if extras.kubelet {
+		applyKubeletConfigs(ctx, cluster, src, nodesList)
+	}
+	if extras.network {
+		names := make([]string, len(nodesList))
+		for i, node := range nodesList {
+			names[i] = node.name
+		}
+		rates, _, err := measureNetwork(ctx, cluster, src, names)
+		if err != nil {
+			return nil, err
+		}
+		applyNodeNetRates(nodesList, rates)
+	}
+
+	for i := range nodesList {
+		nodesList[i].cluster = cluster
+	}
+	return nodesList, nil
+}
+
+// nodesTotal sums resources and cost of all nodes into the cross-cluster total row
+func nodesTotal(nodesList nodeInfoList) nodeInfo {
+	total := nodeInfo{cluster: totalLabel, resources: make(map[string]*resource.Quantity)}
+	for _, node := range nodesList {
+		addResources(total.resources, node.resources)
+		if node.cost != nil {
+			if total.cost == nil {
+				total.cost = &nodeCost{}
+			}
+			total.cost.hourly += node.cost.hourly
+			total.cost.allocated += node.cost.allocated
+		}
+		addNetRate(&total.net, node.net)
+	}
+	return total
+}
+
+// applyNodeUsage adds metrics-server usage to the nodes it reports on
+func applyNodeUsage(nodesList nodeInfoList, nodeMetrics *metricsv1beta1.NodeMetricsList) {
+	if nodeMetrics == nil {
+		return
+	}
+	usage := make(map[string]v1.ResourceList, len(nodeMetrics.Items))
+	for _, metric := range nodeMetrics.Items {
+		usage[metric.Name] = metric.Usage
+	}
+	for _, node := range nodesList {
+		if nodeUsage, ok := usage[node.name]; ok {
+			node.resources["cpuUsage"] = nodeUsage.Cpu()
+			node.resources["memUsage"] = nodeUsage.Memory()
+		}
+	}
+}
+
+// limitPercent is the sum of limits as a percentage of allocatable, for prefix cpu or mem
+func (node nodeInfo) limitPercent(prefix string) (float64, bool) {
+	allocatable := node.resources[prefix+"Allocatable"]
+	if allocatable == nil || allocatable.IsZero() {
+		return 0, false
+	}
+	return float64(node.resources[prefix+"Limit"].MilliValue()) / float64(allocatable.MilliValue()) * 100, true
+}
+
+// burstRisk scores how close a node is to running out when its pods burst to
+// their limits: for the worse of CPU and memory, usage as a percentage of
+// allocatable multiplied by the limit overcommit, when limits exceed
+// allocatable. It needs metrics-server usage.
+func (node nodeInfo) burstRisk() (float64, bool) {
+	risk, ok := 0.0, false
+	for _, prefix := range []string{"cpu", "mem"} {
+		usage, allocatable := node.resources[prefix+"Usage"], node.resources[prefix+"Allocatable"]
+		if usage == nil || allocatable == nil || allocatable.IsZero() {
+			continue
+		}
+		limitPercent, _ := node.limitPercent(prefix)
+		overcommit := math.Max(limitPercent/100, 1)
+		usagePercent := float64(usage.MilliValue()) / float64(allocatable.MilliValue()) * 100
+		risk, ok = math.Max(risk, usagePercent*overcommit), true
+	}
+	return risk, ok
+}
+
+// getNodeInfoList sums container requests and limits of the active pods on each node
+func getNodeInfoList(nodes []v1.Node, pods []v1.Pod) nodeInfoList {
+	// Initialize maps outside loop
+	nodesMeta := make(map[string]map[string]string)
+	nodesResources := make(map[string]map[string]*resource.Quantity)
+	nodesObject := make(map[string]*v1.Node)
+
 	// Initialize node resources
-	for _, node := range nodes.Items {
+	for i, node := range nodes {
+		nodesObject[node.ObjectMeta.Name] = &nodes[i]
 		nodesMeta[node.ObjectMeta.Name] = map[string]string{
-			"arch": node.ObjectMeta.Labels["kubernetes.io/arch"],
-			"os":   node.ObjectMeta.Labels["kubernetes.io/os"],
-			"type": node.ObjectMeta.Labels["node.kubernetes.io/instance-type"],
+			"arch":         node.ObjectMeta.Labels["kubernetes.io/arch"],
+			"os":           node.ObjectMeta.Labels["kubernetes.io/os"],
+			"type":         node.ObjectMeta.Labels["node.kubernetes.io/instance-type"],
+			"capacityType": nodeCapacityType(node.ObjectMeta.Labels),
 		}
+		gpuCapacity := node.Status.Capacity[gpuResource]
 		nodesResources[node.ObjectMeta.Name] = map[string]*resource.Quantity{
-			"cpuReq":      resource.NewQuantity(0, resource.DecimalSI),
-			"cpuLimit":    resource.NewQuantity(0, resource.DecimalSI),
-			"cpuCapacity": node.Status.Capacity.Cpu(),
-			"memReq":      resource.NewQuantity(0, resource.BinarySI),
-			"memLimit":    resource.NewQuantity(0, resource.BinarySI),
-			"memCapacity": node.Status.Capacity.Memory(),
+			"cpuReq":         resource.NewQuantity(0, resource.DecimalSI),
+			"cpuLimit":       resource.NewQuantity(0, resource.DecimalSI),
+			"cpuCapacity":    node.Status.Capacity.Cpu(),
+			"cpuAllocatable": node.Status.Allocatable.Cpu(),
+			"memReq":         resource.NewQuantity(0, resource.BinarySI),
+			"memLimit":       resource.NewQuantity(0, resource.BinarySI),
+			"memCapacity":    node.Status.Capacity.Memory(),
+			"memAllocatable": node.Status.Allocatable.Memory(),
+			"gpuReq":         resource.NewQuantity(0, resource.DecimalSI),
+			"gpuLimit":       resource.NewQuantity(0, resource.DecimalSI),
+			"gpuCapacity":    &gpuCapacity,
 		}
 	}
 
-	// Get and process pods
-	pods, err := client.Clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		panic(err.Error())
-	}
-
 	// Calculate resource usage
-	for _, pod := range pods.Items {
+	for _, pod := range pods {
 		nodeName := pod.Spec.NodeName
 		if _, exists := nodesResources[nodeName]; !exists {
 			continue // Skip pods on unknown nodes
 		}
+		// Completed pods no longer hold their requests on the node
+		if !isActivePod(&pod) {
+			continue
+		}
 
 		for _, container := range pod.Spec.Containers {
 			addContainerResources(nodeName, container, nodesResources)
@@ -123,15 +443,12 @@ func runNodesCommand() {
 	for nodeName, resources := range nodesResources {
 		nodesList = append(nodesList, nodeInfo{
 			name:      nodeName,
+			meta:      nodesMeta[nodeName],
 			resources: resources,
+			object:    nodesObject[nodeName],
 		})
 	}
-
-	// Sort the slice
-	sort.Sort(nodesList)
-
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
-	printTable(w, nodesList)
+	return nodesList
 }
 
 func addContainerResources(nodeName string, container v1.Container, nodesResources map[string]map[string]*resource.Quantity) {
@@ -156,25 +473,36 @@ func addContainerResources(nodeName string, container v1.Container, nodesResourc
 	} else if debug {
 		fmt.Printf("DEBUG: Container %s has nil limits\n", container.Name)
 	}
+
+	// GPUs are optional, so a missing value is not worth a debug line
+	if val, ok := container.Resources.Requests[gpuResource]; ok {
+		nodesResources[nodeName]["gpuReq"].Add(val)
+	}
+	if val, ok := container.Resources.Limits[gpuResource]; ok {
+		nodesResources[nodeName]["gpuLimit"].Add(val)
+	}
 }
 
-func printTable(w *tabwriter.Writer, nodesList nodeInfoList) {
+func printTable(w *tabwriter.Writer, cols []column, nodesList nodeInfoList) {
 	// Print headers
-	fmt.Fprintln(w, strings.Join(getRowValues(nodeInfo{}, true), "\t"))
+	fmt.Fprintln(w, strings.Join(getRowValues(cols, nodeInfo{}, true), "\t"))
 
 	// Print rows
 	for _, node := range nodesList {
-		fmt.Fprintln(w, strings.Join(getRowValues(node, false), "\t"))
+		fmt.Fprintln(w, strings.Join(getRowValues(cols, node, false), "\t"))
 	}
 	w.Flush()
 }
 
-func getRowValues(node nodeInfo, isHeader bool) []string {
-	values := make([]string, len(columns))
-	for i, col := range columns {
-		if isHeader {
+func getRowValues(cols []column, node nodeInfo, isHeader bool) []string {
+	values := make([]string, len(cols))
+	for i, col := range cols {
+		switch {
+		case isHeader && col.colored:
+			values[i] = plainCell(col.header)
+		case isHeader:
 			values[i] = col.header
-		} else {
+		default:
 			values[i] = col.getter(node)
 		}
 	}
@@ -183,8 +511,9 @@ func getRowValues(node nodeInfo, isHeader bool) []string {
 
 func init() {
 	// Initialize base columns
-	columns = []column{
+	nodeColumnCatalog = []column{
 		{
+			id:     "name",
 			header: "NAME",
 			getter: func(node nodeInfo) string {
 				return node.name
@@ -193,25 +522,108 @@ func init() {
 	}
 
 	// Add resource columns dynamically
-	resourceKeys := []string{"cpuCapacity", "cpuReq", "cpuLimit", "memCapacity", "memReq", "memLimit"}
+	resourceKeys := []string{"cpuCapacity", "cpuReq", "cpuLimit", "memCapacity", "memReq", "memLimit", "gpuCapacity", "gpuReq", "gpuLimit"}
 	for _, key := range resourceKeys {
 		col := column{
+			id:     toColumnID(key),
 			header: toColumnName(key),
 			getter: func(key string) func(node nodeInfo) string {
 				return func(node nodeInfo) string {
 					val, suffix := node.resources[key].CanonicalizeBytes(make([]byte, 0, 100))
 					if strings.HasSuffix(key, "Req") {
 						capacity := node.resources[strings.TrimSuffix(key, "Req")+"Capacity"]
-						percentage := (float64(node.resources[key].Value()) / float64(capacity.Value())) * 100
-						return fmt.Sprintf("%s%s (%.2f%%)", string(val), string(suffix), percentage)
+						if capacity.IsZero() {
+							return plainCell(string(val) + string(suffix))
+						}
+						percentage := (float64(node.resources[key].MilliValue()) / float64(capacity.MilliValue())) * 100
+						return percentCell(string(val)+string(suffix), percentage, "%.2f%%")
 					}
 					return string(val) + string(suffix)
 				}
 			}(key),
+			colored: strings.HasSuffix(key, "Req"),
 		}
-		columns = append(columns, col)
+		nodeColumnCatalog = append(nodeColumnCatalog, col)
+	}
+
+	// Add limit overcommit columns. CPU over allocatable only throttles, memory over
+	// allocatable gets pods OOM-killed when they burst.
+	nodeColumnCatalog = append(nodeColumnCatalog,
+		column{
+			id:     "cpu-limit-alloc",
+			header: "CPU LIMIT/ALLOC",
+			getter: func(node nodeInfo) string {
+				percent, ok := node.limitPercent("cpu")
+				if !ok {
+					return plainCell("-")
+				}
+				color := colorGreen
+				if percent > 100 {
+					color = colorYellow
+				}
+				return ratioCell(color, fmt.Sprintf("%.0f%%", percent), percent)
+			},
+			colored: true,
+		},
+		column{
+			id:     "mem-limit-alloc",
+			header: "MEM LIMIT/ALLOC",
+			getter: func(node nodeInfo) string {
+				percent, ok := node.limitPercent("mem")
+				if !ok {
+					return plainCell("-")
+				}
+				if percent > 100 {
+					return ratioCell(colorRed, fmt.Sprintf("%.0f%%", percent), percent)
+				}
+				return ratioCell(colorGreen, fmt.Sprintf("%.0f%%", percent), percent)
+			},
+			colored: true,
+		},
+		column{
+			id:     "burst-risk",
+			header: "BURST RISK",
+			getter: func(node nodeInfo) string {
+				risk, ok := node.burstRisk()
+				if !ok {
+					return plainCell("<none>")
+				}
+				return ratioCell(thresholdColor(risk), fmt.Sprintf("%.0f", risk), risk)
+			},
+			colored: true,
+		},
+	)
+	nodeColumnCatalog = append(nodeColumnCatalog, nodeUsageColumns...)
+	nodeColumnCatalog = append(nodeColumnCatalog, nodeNetColumns...)
+
+	// Add label columns
+	metaKeys := []string{"arch", "os", "type", "capacityType"}
+	for _, key := range metaKeys {
+		nodeColumnCatalog = append(nodeColumnCatalog, column{
+			id:     toColumnID(key),
+			header: toColumnName(key),
+			getter: func(node nodeInfo) string {
+				return node.meta[key]
+			},
+		})
+	}
+	nodeColumnCatalog = append(nodeColumnCatalog, nodeKubeletColumns...)
+	nodeColumnCatalog = append(nodeColumnCatalog, nodeCostColumns...)
+	nodeColumnCatalog = append(nodeColumnCatalog, nodeClusterColumn)
+
+	var nodeColumnIDs []string
+	for _, col := range nodeColumnCatalog {
+		nodeColumnIDs = append(nodeColumnIDs, col.id)
 	}
 
 	rootCmd.AddCommand(nodesCmd)
-	nodesCmd.Flags().StringVar(&sortBy, "sort-by", "name", "Sort nodes by: name, cpu-req, cpu-limit, mem-req, mem-limit")
+	nodesCmd.Flags().StringVar(&sortBy, "sort-by", "name", "Sort nodes by: name, cpu-req, cpu-limit, mem-req, mem-limit, burst-risk, net-rx, net-tx")
+	addCostFlags(nodesCmd)
+	addDisplayFlags(nodesCmd)
+	addColumnFlags(nodesCmd, nodeColumnIDs)
+	addSampleIntervalFlag(nodesCmd)
+	nodesCmd.Flags().BoolVar(&showOvercommit, "overcommit", false, "Show limits as a share of allocatable and the burst risk score; memory limits over 100% are red, a risk of OOM kills")
+	nodesCmd.Flags().BoolVar(&showNodePods, "pods", false, "Show the pods of every node under it, with their requests and usage as a share of the node")
+	nodesCmd.Flags().BoolVar(&collapseDaemonSets, "collapse-daemonsets", false, "With --pods, fold the DaemonSet pods of every node into one row")
+	nodesCmd.Flags().BoolVar(&showKubelet, "kubelet", false, "Show reserved resources, eviction thresholds and max pods from each kubelet's configz, flagging nodes that differ from their node group")
 }
@@ -0,0 +1,179 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	// showNodePods prints every node followed by its pods
+	showNodePods bool
+	// collapseDaemonSets folds the DaemonSet pods of a node into one row of the tree
+	collapseDaemonSets bool
+)
+
+// nodeUsageColumnIDs are added to the defaults by --pods, for the usage totals of every node
+var nodeUsageColumnIDs = []string{"cpu-usage", "mem-usage"}
+
+var nodeUsageColumns = []column{
+	{
+		id:      "cpu-usage",
+		header:  "CPU USAGE",
+		getter:  func(node nodeInfo) string { return nodeUsageCell(node, "cpu") },
+		colored: true,
+	},
+	{
+		id:      "mem-usage",
+		header:  "MEM USAGE",
+		getter:  func(node nodeInfo) string { return nodeUsageCell(node, "mem") },
+		colored: true,
+	},
+}
+
+// nodeUsageCell renders metrics-server usage with its share of capacity, like the request columns
+func nodeUsageCell(node nodeInfo, prefix string) string {
+	usage := node.resources[prefix+"Usage"]
+	if usage == nil {
+		return plainCell("<none>")
+	}
+	return shareCell(usage, node.resources[prefix+"Capacity"])
+}
+
+// shareCell renders a quantity with its percentage of the node's capacity
+func shareCell(q, capacity *resource.Quantity) string {
+	if capacity == nil || capacity.IsZero() {
+		return plainCell(formatQuantity(*q))
+	}
+	percentage := float64(q.MilliValue()) / float64(capacity.MilliValue()) * 100
+	return percentCell(formatQuantity(*q), percentage, "%.2f%%")
+}
+
+// attachNodePods hands the active pods to the nodes they run on, with the
+// DaemonSet pods of every node folded into one row when asked for
+func attachNodePods(nodesList nodeInfoList, podsList podInfoList) {
+	byNode := make(map[string]podInfoList)
+	for _, pod := range podsList {
+		if pod.phase == "Succeeded" || pod.phase == "Failed" || pod.nodeName == "" {
+			continue
+		}
+		byNode[pod.nodeName] = append(byNode[pod.nodeName], pod)
+	}
+	for i := range nodesList {
+		pods := byNode[nodesList[i].name]
+		if collapseDaemonSets {
+			pods = collapseDaemonSetPods(pods)
+		}
+		sortNodePods(pods)
+		nodesList[i].pods = pods
+	}
+}
+
+// collapseDaemonSetPods replaces the DaemonSet pods of a node by one row summing them
+func collapseDaemonSetPods(pods podInfoList) podInfoList {
+	collapsed := make(podInfoList, 0, len(pods))
+	daemonSets := podInfo{
+		resources: make(map[string]*resource.Quantity),
+		cpuUsage:  resource.NewQuantity(0, resource.DecimalSI),
+		memUsage:  resource.NewQuantity(0, resource.BinarySI),
+	}
+	count := 0
+	for _, pod := range pods {
+		if pod.workload.kind != "DaemonSet" {
+			collapsed = append(collapsed, pod)
+			continue
+		}
+		count++
+		addResources(daemonSets.resources, pod.resources)
+		daemonSets.cpuUsage.Add(*pod.cpuUsage)
+		daemonSets.memUsage.Add(*pod.memUsage)
+	}
+	if count == 0 {
+		return pods
+	}
+	daemonSets.name = fmt.Sprintf("<%d DaemonSet pods>", count)
+	if count == 1 {
+		daemonSets.name = "<1 DaemonSet pod>"
+	}
+	return append(collapsed, daemonSets)
+}
+
+// sortNodePods orders the pods of a node by the --sort-by key where pods have
+// it, like the nodes themselves, and by name otherwise
+func sortNodePods(pods podInfoList) {
+	sortMap := map[string]string{
+		"cpu-req":   "cpuReq",
+		"cpu-limit": "cpuLimit",
+		"mem-req":   "memReq",
+		"mem-limit": "memLimit",
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		if resourceKey, ok := sortMap[sortBy]; ok {
+			if c := pods[i].resources[resourceKey].Cmp(*pods[j].resources[resourceKey]); c != 0 {
+				return c < 0
+			}
+		}
+		if pods[i].namespace != pods[j].namespace {
+			return pods[i].namespace < pods[j].namespace
+		}
+		return pods[i].name < pods[j].name
+	})
+}
+
+// printNodeTree prints every node row followed by its pods, indented under the
+// node name, with their requests and usage as a share of the node
+func printNodeTree(w *tabwriter.Writer, cols []column, nodesList nodeInfoList) {
+	fmt.Fprintln(w, strings.Join(getRowValues(cols, nodeInfo{}, true), "\t"))
+	for _, node := range nodesList {
+		fmt.Fprintln(w, strings.Join(getRowValues(cols, node, false), "\t"))
+		for i, pod := range node.pods {
+			branch := "├─ "
+			if i == len(node.pods)-1 {
+				branch = "└─ "
+			}
+			values := make([]string, len(cols))
+			for j, col := range cols {
+				values[j] = nodePodCell(col, node, pod, branch)
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+	}
+	w.Flush()
+}
+
+// nodePodCell renders a node column for a pod of the tree. Columns without a
+// pod counterpart stay empty.
+func nodePodCell(col column, node nodeInfo, pod podInfo, branch string) string {
+	var value string
+	switch col.id {
+	case "name":
+		value = branch + pod.name
+		if pod.namespace != "" {
+			value = branch + pod.namespace + "/" + pod.name
+		}
+	case "cpu-req":
+		return shareCell(pod.resources["cpuReq"], node.resources["cpuCapacity"])
+	case "mem-req":
+		return shareCell(pod.resources["memReq"], node.resources["memCapacity"])
+	case "cpu-usage":
+		return shareCell(pod.cpuUsage, node.resources["cpuCapacity"])
+	case "mem-usage":
+		return shareCell(pod.memUsage, node.resources["memCapacity"])
+	case "cpu-limit":
+		value = formatQuantity(*pod.resources["cpuLimit"])
+	case "mem-limit":
+		value = formatQuantity(*pod.resources["memLimit"])
+	case "cluster":
+		value = node.cluster
+	}
+	if col.colored {
+		return plainCell(value)
+	}
+	return value
+}
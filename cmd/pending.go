@@ -0,0 +1,354 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// pendingEventLimit is the number of distinct FailedScheduling events shown per pod
+const pendingEventLimit = 3
+
+// nodeGroupLabels name the node group or pool of a node, by provider
+var nodeGroupLabels = []string{
+	"karpenter.sh/nodepool",
+	"eks.amazonaws.com/nodegroup",
+	"alpha.eksctl.io/nodegroup-name",
+	"cloud.google.com/gke-nodepool",
+	"kubernetes.azure.com/agentpool",
+}
+
+var (
+	pendingNamespace     string
+	pendingAllNamespaces bool
+	pendingNodes         bool
+)
+
+var pendingCmd = &cobra.Command{
+	Use:   "pending",
+	Short: "Explain why pending pods are not scheduled",
+	Long: `List pods that are Pending without a node, with their requests, the scheduler's
+PodScheduled condition and recent FailedScheduling events.
+
+xtop also checks every node itself for insufficient CPU, memory or GPU, max
+pods, cordons, untolerated taints, nodeSelector and required node affinity,
+and summarizes why nodes are ruled out. Pod (anti-)affinity and topology
+spread constraints are not evaluated. Finally it suggests the smallest
+existing node group, by allocatable CPU and memory, whose nodes would fit the
+pod next to their DaemonSets, which is what a scale-up of that group gives.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runPendingCommand()
+	},
+}
+
+// nodeGroup is the set of nodes sharing a node group label and instance type
+type nodeGroup struct {
+	name  string
+	nodes int
+	// node is the first node of the group by name, used as its template
+	node *v1.Node
+	// daemonSetRequests are the requests of DaemonSet pods on the template node,
+	// which a new node of the group runs as well
+	daemonSetRequests v1.ResourceList
+}
+
+func runPendingCommand() error {
+	pendingNs := pendingNamespace
+	if pendingAllNamespaces {
+		pendingNs = ""
+	} else if pendingNs == "" {
+		pendingNs = dataSource.DefaultNamespace()
+	}
+
+	// Pods of all namespaces are needed for what is already allocated on nodes
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{Nodes: true, Pods: true})
+	if err != nil {
+		return err
+	}
+	// Events only of the pending pods' namespace, which FetchState cannot read
+	// apart from the pods of all namespaces. The analysis does not need them,
+	// so a missing events RBAC only leaves them out.
+	events, eventsErr := dataSource.FailedSchedulingEvents(ctx, pendingNs)
+	if eventsErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not fetch FailedScheduling events: %v\n", eventsErr)
+	}
+
+	var pending []*v1.Pod
+	for i := range state.Pods {
+		pod := &state.Pods[i]
+		if isUnscheduled(pod) && (pendingNs == "" || pod.Namespace == pendingNs) {
+			pending = append(pending, pod)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Namespace != pending[j].Namespace {
+			return pending[i].Namespace < pending[j].Namespace
+		}
+		return pending[i].Name < pending[j].Name
+	})
+	if len(pending) == 0 {
+		fmt.Println("No unscheduled pods")
+		return nil
+	}
+
+	allocations := buildNodeAllocations(state.Nodes, state.Pods)
+	groups := getNodeGroups(state.Nodes, state.Pods)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	for i, pod := range pending {
+		if i > 0 {
+			fmt.Println()
+		}
+		requests := podRequests(&pod.Spec)
+		results := evaluateFit(allocations, &pod.Spec, requests)
+
+		ref := podWorkload(pod)
+		fmt.Fprintf(w, "Pod:\t%s/%s (%s/%s)\n", pod.Namespace, pod.Name, ref.kind, ref.name)
+		fmt.Fprintf(w, "Requests:\t%s\n", formatRequests(requests))
+		fmt.Fprintf(w, "Scheduler:\t%s\n", scheduledCondition(pod))
+		podEvents := podFailedSchedulingEvents(pod, events)
+		switch {
+		case eventsErr != nil:
+			fmt.Fprintf(w, "Events:\t<unknown>\n")
+		case len(podEvents) == 0:
+			fmt.Fprintf(w, "Events:\t<none>\n")
+		}
+		for j, event := range podEvents {
+			label := "Events:"
+			if j > 0 {
+				label = ""
+			}
+			fmt.Fprintf(w, "%s\t%s\n", label, formatSchedulingEvent(event))
+		}
+		fmt.Fprintf(w, "Nodes:\t%s\n", summarizeFit(results))
+		fmt.Fprintf(w, "Suggestion:\t%s\n", suggestNodeGroup(results, groups, &pod.Spec, requests))
+		w.Flush()
+
+		if pendingNodes {
+			fmt.Println()
+			printFitTable(w, results)
+		}
+	}
+	return nil
+}
+
+// isUnscheduled reports whether a pod waits for the scheduler
+func isUnscheduled(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodPending && pod.Spec.NodeName == ""
+}
+
+// scheduledCondition renders the PodScheduled condition the scheduler set on the pod
+func scheduledCondition(pod *v1.Pod) string {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != v1.PodScheduled || cond.Status == v1.ConditionTrue {
+			continue
+		}
+		if cond.Message == "" {
+			return cond.Reason
+		}
+		return cond.Reason + ": " + cond.Message
+	}
+	return "<none>"
+}
+
+// podFailedSchedulingEvents returns the most recent FailedScheduling events of a pod, newest first
+func podFailedSchedulingEvents(pod *v1.Pod, events []v1.Event) []v1.Event {
+	var matched []v1.Event
+	for _, event := range events {
+		involved := event.InvolvedObject
+		if involved.Kind != "Pod" || involved.Namespace != pod.Namespace || involved.Name != pod.Name {
+			continue
+		}
+		// An event of an earlier pod with the same name
+		if involved.UID != "" && pod.UID != "" && involved.UID != pod.UID {
+			continue
+		}
+		matched = append(matched, event)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return eventTime(&matched[i]).After(eventTime(&matched[j]))
+	})
+	if len(matched) > pendingEventLimit {
+		matched = matched[:pendingEventLimit]
+	}
+	return matched
+}
+
+// eventTime is when an event was last seen
+func eventTime(event *v1.Event) time.Time {
+	switch {
+	case event.Series != nil:
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.FirstTimestamp.Time
+	}
+}
+
+func formatSchedulingEvent(event v1.Event) string {
+	count := event.Count
+	if event.Series != nil {
+		count = event.Series.Count
+	}
+	if count < 1 {
+		count = 1
+	}
+	age := "<unknown>"
+	if last := eventTime(&event); !last.IsZero() {
+		age = duration.HumanDuration(time.Since(last)) + " ago"
+	}
+	return fmt.Sprintf("%dx, last %s: %s", count, age, strings.TrimSpace(event.Message))
+}
+
+// summarizeFit counts the nodes that fit and, for the others, every reason
+// that rules them out, most frequent first
+func summarizeFit(results []fitResult) string {
+	fitting := 0
+	counts := make(map[string]int)
+	for _, r := range results {
+		if len(r.reasons) == 0 {
+			fitting++
+			continue
+		}
+		for _, reason := range r.reasons {
+			counts[reason]++
+		}
+	}
+
+	reasons := sortedKeys(counts)
+	sort.SliceStable(reasons, func(i, j int) bool { return counts[reasons[i]] > counts[reasons[j]] })
+	parts := []string{fmt.Sprintf("%d of %d fit", fitting, len(results))}
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[reason], reason))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// getNodeGroups groups nodes by node group label and instance type
+func getNodeGroups(nodes []v1.Node, pods []v1.Pod) []*nodeGroup {
+	sorted := make([]*v1.Node, len(nodes))
+	for i := range nodes {
+		sorted[i] = &nodes[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	byName := make(map[string]*nodeGroup)
+	// templates maps the name of every template node to its group
+	templates := make(map[string]*nodeGroup)
+	var groups []*nodeGroup
+	for _, node := range sorted {
+		name := nodeGroupName(node)
+		group, ok := byName[name]
+		if !ok {
+			group = &nodeGroup{name: name, node: node, daemonSetRequests: v1.ResourceList{}}
+			byName[name] = group
+			templates[node.Name] = group
+			groups = append(groups, group)
+		}
+		group.nodes++
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		group, ok := templates[pod.Spec.NodeName]
+		if !ok || !isActivePod(pod) || !isDaemonSetPod(pod) {
+			continue
+		}
+		for name, val := range podRequests(&pod.Spec) {
+			sum := group.daemonSetRequests[name]
+			sum.Add(val)
+			group.daemonSetRequests[name] = sum
+		}
+	}
+	return groups
+}
+
+// nodeGroupName is the node group label, if any, with the instance type
+func nodeGroupName(node *v1.Node) string {
+	instanceType := node.Labels["node.kubernetes.io/instance-type"]
+	if instanceType == "" {
+		instanceType = "<unknown type>"
+	}
+	for _, label := range nodeGroupLabels {
+		if group, ok := node.Labels[label]; ok {
+			return group + " (" + instanceType + ")"
+		}
+	}
+	return instanceType
+}
+
+// fitsNewNode reports whether a pod fits an empty node of the group next to its DaemonSets
+func (g *nodeGroup) fitsNewNode(spec *v1.PodSpec, requests v1.ResourceList) bool {
+	if len(constraintReasons(g.node, spec)) > 0 {
+		return false
+	}
+	for _, name := range schedulingResources {
+		want, ok := requests[name]
+		if !ok || want.IsZero() {
+			continue
+		}
+		free := g.node.Status.Allocatable[name].DeepCopy()
+		free.Sub(g.daemonSetRequests[name])
+		if free.Cmp(want) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// suggestNodeGroup names the smallest node group a new node of which fits the pod
+func suggestNodeGroup(results []fitResult, groups []*nodeGroup, spec *v1.PodSpec, requests v1.ResourceList) string {
+	for _, r := range results {
+		if len(r.reasons) == 0 {
+			return "fits existing nodes now, the scheduler may not have retried yet"
+		}
+	}
+
+	var smallest *nodeGroup
+	for _, group := range groups {
+		if !group.fitsNewNode(spec, requests) {
+			continue
+		}
+		if smallest == nil || smallerNode(group.node, smallest.node) {
+			smallest = group
+		}
+	}
+	if smallest == nil {
+		return fmt.Sprintf("none of the %d node groups fits the pod, a new node group is needed", len(groups))
+	}
+	allocatable := smallest.node.Status.Allocatable
+	return fmt.Sprintf("scale up %s, smallest group that fits (%s cpu, %s memory allocatable)",
+		smallest.name, formatQuantity(*allocatable.Cpu()), formatQuantity(*allocatable.Memory()))
+}
+
+// smallerNode orders nodes by allocatable CPU, then memory
+func smallerNode(a, b *v1.Node) bool {
+	if c := a.Status.Allocatable.Cpu().Cmp(*b.Status.Allocatable.Cpu()); c != 0 {
+		return c < 0
+	}
+	return a.Status.Allocatable.Memory().Cmp(*b.Status.Allocatable.Memory()) < 0
+}
+
+func init() {
+	rootCmd.AddCommand(pendingCmd)
+	pendingCmd.Flags().StringVarP(&pendingNamespace, "namespace", "n", "", "Show pending pods in the specified namespace")
+	pendingCmd.Flags().BoolVarP(&pendingAllNamespaces, "all-namespaces", "A", false, "Show pending pods from all namespaces")
+	pendingCmd.Flags().BoolVar(&pendingNodes, "nodes", false, "Show the per-node analysis of every pod")
+}
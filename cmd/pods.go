@@ -7,47 +7,71 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 	"text/tabwriter"
 
 	client "github.com/akomic/kubectl-xtop/client"
-	v1 "k8s.io/api/core/v1"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
-	"k8s.io/client-go/tools/clientcmd"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 )
 
 var (
-	podSortBy    string
-	namespace    string
-	verbose      bool
+	podSortBy     string
+	namespace     string
+	verbose       bool
 	allNamespaces bool
 )
 
 var podsCmd = &cobra.Command{
-	Use:   "pods",
-	Short: "Top pods",
-	Run: func(cmd *cobra.Command, args []string) {
-		runPodsCommand()
+	Use:         "pods",
+	Short:       "Top pods",
+	Annotations: map[string]string{multiClusterAnnotation: "true"},
+	Long: `Show the requests, limits and usage of pods.
+
+Requests already include the defaults LimitRange admission gave containers
+without them when the pod was created. The requests column tells which
+containers got defaults and which still miss a CPU or memory request.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runPodsCommand()
 	},
 }
 
 type podColumn struct {
+	// id selects the column with --columns
+	id     string
 	header string
 	getter func(podInfo) string
+	// colored columns paint every cell, so their header is painted too
+	colored bool
 }
 
 type podInfo struct {
-	name       string
-	namespace  string
-	nodeName   string
-	resources  map[string]*resource.Quantity
-	phase      string
-	cpuUsage   *resource.Quantity
-	memUsage   *resource.Quantity
+	cluster      string
+	name         string
+	namespace    string
+	nodeName     string
+	resources    map[string]*resource.Quantity
+	phase        string
+	cpuUsage     *resource.Quantity
+	memUsage     *resource.Quantity
+	crashLooping bool
+	hourlyCost   float64
+	workload     workloadRef
+	qosClass     string
+	requests     requestCoverage
+	// vpaTarget sums the VPA recommended targets of the containers, nil without a recommendation
+	vpaTarget v1.ResourceList
+	// net is the measured network throughput, nil when not measured
+	net *netRate
+	// throttling and memory are read from cAdvisor, nil when not read
+	throttling *throttling
+	memory     *memoryBreakdown
+	// object is the Pod read from the cluster, for custom columns
+	object *v1.Pod
 }
 
 func toPodColumnName(key string) string {
@@ -59,20 +83,24 @@ func toPodColumnName(key string) string {
 	return strings.ToUpper(key)
 }
 
-var podColumns = []podColumn{
+// podColumnCatalog holds every built-in pod column
+var podColumnCatalog = []podColumn{
 	{
+		id:     "namespace",
 		header: "NAMESPACE",
 		getter: func(pod podInfo) string {
 			return pod.namespace
 		},
 	},
 	{
+		id:     "name",
 		header: "NAME",
 		getter: func(pod podInfo) string {
 			return pod.name
 		},
 	},
 	{
+		id:     "status",
 		header: "STATUS",
 		getter: func(pod podInfo) string {
 			return pod.phase
@@ -80,6 +108,85 @@ var podColumns = []podColumn{
 	},
 }
 
+// defaultPodColumns are shown when --columns is not given
+var defaultPodColumns = []string{"namespace", "name", "status", "cpu-req", "cpu-limit", "cpu-usage", "mem-req", "mem-limit", "mem-usage"}
+
+var podRequestsColumn = podColumn{
+	id:     "requests",
+	header: "REQUESTS",
+	getter: func(pod podInfo) string {
+		if pod.object == nil {
+			return ""
+		}
+		return pod.requests.String()
+	},
+}
+
+var podQoSColumn = podColumn{
+	id:     "qos",
+	header: "QOS",
+	getter: func(pod podInfo) string {
+		return pod.qosClass
+	},
+}
+
+var podNodeColumn = podColumn{
+	id:     "node",
+	header: "NODE",
+	getter: func(pod podInfo) string {
+		return pod.nodeName
+	},
+}
+
+var podWorkloadColumn = podColumn{
+	id:     "workload",
+	header: "WORKLOAD",
+	getter: func(pod podInfo) string {
+		if pod.workload.name == "" {
+			return ""
+		}
+		return pod.workload.kind + "/" + pod.workload.name
+	},
+}
+
+var podCostColumns = []podColumn{
+	{
+		id:     "cost-hr",
+		header: "COST/HR",
+		getter: func(pod podInfo) string {
+			return formatCost(pod.hourlyCost)
+		},
+	},
+	{
+		id:     "cost-month",
+		header: "COST/MONTH",
+		getter: func(pod podInfo) string {
+			return formatMonthlyCost(pod.hourlyCost)
+		},
+	},
+}
+
+var podClusterColumn = podColumn{
+	id:     "cluster",
+	header: "CLUSTER",
+	getter: func(pod podInfo) string {
+		return pod.cluster
+	},
+}
+
+// podExtras selects the optional data collectPods reads besides pods and metrics
+type podExtras struct {
+	// vpa is the VPA recommendations; VPAs are a CRD that may be missing or forbidden
+	vpa bool
+	// limitRanges tell the REQUESTS column which pods predate a LimitRange
+	limitRanges bool
+	// network is throughput measured from two kubelet stats samples
+	network bool
+	// throttling is measured from two cAdvisor samples, memory needs one
+	throttling bool
+	memory     bool
+}
+
 type podInfoList []podInfo
 
 func (p podInfoList) Len() int      { return len(p) }
@@ -91,60 +198,203 @@ func (p podInfoList) Less(i, j int) bool {
 		"mem-req":   "memReq",
 		"mem-limit": "memLimit",
 	}
-	
+
 	if resourceKey, ok := sortMap[podSortBy]; ok {
 		return p[i].resources[resourceKey].Cmp(*p[j].resources[resourceKey]) < 0
 	}
+	if isNetSort(podSortBy) {
+		rateI, rateJ := p[i].net.value(podSortBy), p[j].net.value(podSortBy)
+		if rateI != rateJ {
+			return rateI < rateJ
+		}
+	}
+	if podSortBy == "throttled" {
+		throttledI, throttledJ := p[i].throttledValue(), p[j].throttledValue()
+		if throttledI != throttledJ {
+			return throttledI < throttledJ
+		}
+	}
+	if p[i].name == p[j].name {
+		return p[i].cluster < p[j].cluster
+	}
 	return p[i].name < p[j].name
 }
 
-func runPodsCommand() {
-	// Add NODE column if verbose flag is set
+func runPodsCommand() error {
+	prices, err := validateCostFlags()
+	if err != nil {
+		return err
+	}
+	if err := validateDisplayFlags(); err != nil {
+		return err
+	}
+
+	custom, err := parseOutputFormat()
+	if err != nil {
+		return err
+	}
+	cols, err := podTableColumns(prices != nil, custom)
+	if err != nil {
+		return err
+	}
+
+	// Only read what the columns and sort need
+	extras := podExtras{
+		vpa:         slices.ContainsFunc(cols, func(col podColumn) bool { return col.id == podVPATargetColumn.id }),
+		limitRanges: slices.ContainsFunc(cols, func(col podColumn) bool { return col.id == podRequestsColumn.id }),
+		network:     slices.ContainsFunc(cols, func(col podColumn) bool { return slices.Contains(netColumnIDs, col.id) }) || isNetSort(podSortBy),
+		throttling:  slices.ContainsFunc(cols, func(col podColumn) bool { return slices.Contains(throttleColumnIDs, col.id) }) || podSortBy == "throttled",
+		memory:      slices.ContainsFunc(cols, func(col podColumn) bool { return slices.Contains(memoryColumnIDs, col.id) }),
+	}
+
+	// Get pods from every cluster
+	results := queryClusters(func(ctx context.Context, cluster string, src client.Source) (podInfoList, error) {
+		return collectPods(ctx, cluster, src, prices, extras)
+	})
+	succeeded, err := succeededClusters(results)
+	if err != nil {
+		return err
+	}
+	var podsList podInfoList
+	for _, result := range succeeded {
+		podsList = append(podsList, result.value...)
+	}
+
+	// Sort the slice
+	sort.Sort(podsList)
+
+	if multiCluster() && len(podsList) > 0 {
+		podsList = append(podsList, podsTotal(podsList))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	printPodTable(w, cols, podsList)
+	if multiCluster() {
+		printFailedClusters(results)
+	}
+	return nil
+}
+
+// podTableColumns picks the columns of this run: --columns or the defaults
+// with NODE when verbose and cost columns when priced, then custom columns,
+// and CLUSTER first when querying several clusters
+func podTableColumns(priced bool, custom []customColumn) ([]podColumn, error) {
+	defaults := append([]string{}, defaultPodColumns...)
 	if verbose {
-		nodeColumn := podColumn{
-			header: "NODE",
+		defaults = append(defaults, podNodeColumn.id)
+	}
+	if isNetSort(podSortBy) {
+		defaults = append(defaults, netColumnIDs...)
+	}
+	if podSortBy == "throttled" {
+		defaults = append(defaults, throttleColumnIDs...)
+	}
+	if priced {
+		for _, col := range podCostColumns {
+			defaults = append(defaults, col.id)
+		}
+	}
+	ids := requestedColumnIDs(defaults, custom)
+	if err := validateCostColumns(ids, priced); err != nil {
+		return nil, err
+	}
+	cols, err := selectColumns(podColumnCatalog, func(col podColumn) string { return col.id }, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range custom {
+		cols = append(cols, podColumn{
+			header: c.header,
 			getter: func(pod podInfo) string {
-				return pod.nodeName
+				if pod.object == nil {
+					return ""
+				}
+				return c.value(pod.object)
 			},
-		}
-		podColumns = append(podColumns, nodeColumn)
+		})
+	}
+
+	if multiCluster() && !slices.Contains(ids, podClusterColumn.id) {
+		cols = append([]podColumn{podClusterColumn}, cols...)
 	}
-	// Get pods
-	var pods *v1.PodList
-	var err error
-	
+	return cols, nil
+}
+
+// collectPods lists the pods of one cluster with their usage, priced when a
+// price table is given and with the extras asked for
+func collectPods(ctx context.Context, cluster string, src client.Source, prices *priceTable, extras podExtras) (podInfoList, error) {
+	// Get current namespace from config if not specified
+	podNamespace := namespace
 	if allNamespaces {
-		pods, err = client.Clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
-	} else {
-		// Get current namespace from config if not specified
-		if namespace == "" {
-			loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-			configOverrides := &clientcmd.ConfigOverrides{}
-			kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-			namespace, _, err = kubeConfig.Namespace()
-			if err != nil || namespace == "" {
-				namespace = "default"
-			}
-		}
-		pods, err = client.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+		podNamespace = ""
+	} else if podNamespace == "" {
+		podNamespace = src.DefaultNamespace()
 	}
-	
+
+	// Get pods, metrics and, for pricing, nodes in parallel
+	state, err := client.FetchState(ctx, src, client.FetchRequest{
+		Nodes:      prices != nil,
+		Pods:       true,
+		Namespace:  podNamespace,
+		PodMetrics: true,
+		// LimitRanges only for the REQUESTS column
+		LimitRanges: extras.limitRanges,
+		// VerticalPodAutoscalers only for the VPA TARGET column
+		VerticalPodAutoscalers: extras.vpa,
+	})
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+	if state.MetricsErr != nil {
+		metricsWarning(cluster, state.MetricsErr)
 	}
 
-	// Get metrics client
-	metricsClient, err := metrics.NewForConfig(client.Config)
-	if err != nil {
-		panic(err.Error())
+	if state.LimitRangesErr != nil {
+		limitRangesWarning(cluster, state.LimitRangesErr)
 	}
 
-	// Get pod metrics
-	podMetrics, err := metricsClient.MetricsV1beta1().PodMetricses("").List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		fmt.Printf("Warning: Could not fetch metrics: %v\n", err)
+	podsList := getPodInfoList(state.Pods, state.PodMetrics)
+	if prices != nil {
+		applyCosts(prices, getNodeInfoList(state.Nodes, nil), podsList)
+	}
+
+	setRequestCoverage(podsList, requestCoverageByPod(state.Pods, namespaceContainerDefaults(state.LimitRanges)))
+	setVPATargets(podsList, state.VerticalPodAutoscalers)
+	if err := applyKubeletSamples(ctx, cluster, src, state.Pods, podsList, extras); err != nil {
+		return nil, err
+	}
+	for i := range podsList {
+		podsList[i].cluster = cluster
+	}
+	return podsList, nil
+}
+
+// podsTotal sums requests, limits, usage and cost of all pods into the cross-cluster total row
+func podsTotal(podsList podInfoList) podInfo {
+	total := podInfo{
+		cluster:   totalLabel,
+		resources: make(map[string]*resource.Quantity),
+		cpuUsage:  resource.NewQuantity(0, resource.DecimalSI),
+		memUsage:  resource.NewQuantity(0, resource.BinarySI),
+	}
+	for _, pod := range podsList {
+		addResources(total.resources, pod.resources)
+		if pod.cpuUsage != nil {
+			total.cpuUsage.Add(*pod.cpuUsage)
+		}
+		if pod.memUsage != nil {
+			total.memUsage.Add(*pod.memUsage)
+		}
+		total.hourlyCost += pod.hourlyCost
+		addNetRate(&total.net, pod.net)
+		addCadvisor(&total, pod)
 	}
+	return total
+}
 
+// getPodInfoList sums container requests and limits per pod and attaches metrics-server usage
+func getPodInfoList(pods []v1.Pod, podMetrics *metricsv1beta1.PodMetricsList) podInfoList {
 	// Create metrics lookup map
 	metricsMap := make(map[string]map[string]*resource.Quantity)
 	if podMetrics != nil {
@@ -154,7 +404,7 @@ func runPodsCommand() {
 				"cpu":    resource.NewQuantity(0, resource.DecimalSI),
 				"memory": resource.NewQuantity(0, resource.BinarySI),
 			}
-			
+
 			for _, container := range podMetric.Containers {
 				metricsMap[key]["cpu"].Add(container.Usage[v1.ResourceCPU])
 				metricsMap[key]["memory"].Add(container.Usage[v1.ResourceMemory])
@@ -163,9 +413,9 @@ func runPodsCommand() {
 	}
 
 	// Convert to podInfo list
-	podsList := make(podInfoList, 0, len(pods.Items))
-	
-	for _, pod := range pods.Items {
+	podsList := make(podInfoList, 0, len(pods))
+
+	for i, pod := range pods {
 		resources := map[string]*resource.Quantity{
 			"cpuReq":   resource.NewQuantity(0, resource.DecimalSI),
 			"cpuLimit": resource.NewQuantity(0, resource.DecimalSI),
@@ -195,13 +445,17 @@ func runPodsCommand() {
 		}
 
 		info := podInfo{
-			name:      pod.Name,
-			namespace: pod.Namespace,
-			nodeName:  pod.Spec.NodeName,
-			resources: resources,
-			phase:     string(pod.Status.Phase),
-			cpuUsage:  resource.NewQuantity(0, resource.DecimalSI),
-			memUsage:  resource.NewQuantity(0, resource.BinarySI),
+			name:         pod.Name,
+			namespace:    pod.Namespace,
+			nodeName:     pod.Spec.NodeName,
+			resources:    resources,
+			phase:        string(pod.Status.Phase),
+			crashLooping: isCrashLooping(&pod),
+			workload:     podWorkload(&pod),
+			qosClass:     string(pod.Status.QOSClass),
+			cpuUsage:     resource.NewQuantity(0, resource.DecimalSI),
+			memUsage:     resource.NewQuantity(0, resource.BinarySI),
+			object:       &pods[i],
 		}
 
 		// Add metrics if available
@@ -213,31 +467,98 @@ func runPodsCommand() {
 
 		podsList = append(podsList, info)
 	}
+	return podsList
+}
 
-	// Sort the slice
-	sort.Sort(podsList)
+// applyKubeletSamples reads the kubelet stats and cAdvisor metrics of the nodes
+// running the pods, sampling twice when rates are asked for
+func applyKubeletSamples(ctx context.Context, cluster string, src client.Source, pods []v1.Pod, podsList podInfoList, extras podExtras) error {
+	cadvisor := extras.throttling || extras.memory
+	if !extras.network && !cadvisor {
+		return nil
+	}
+	nodeNames := podNodeNames(pods)
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
-	printPodTable(w, podsList)
+	var first, last kubeletSample
+	if extras.network || extras.throttling {
+		var err error
+		first, last, err = sampleKubeletTwice(ctx, cluster, src, nodeNames, extras.network, cadvisor)
+		if err != nil {
+			return err
+		}
+	} else {
+		last = sampleKubelet(ctx, cluster, src, nodeNames, false, true)
+	}
+
+	if extras.network {
+		_, rates := networkRates(first.stats, last.stats)
+		applyPodNetRates(podsList, rates)
+	}
+	if cadvisor {
+		applyCadvisor(podsList, first.cadvisor, last.cadvisor)
+	}
+	return nil
+}
+
+// podNodeNames returns the nodes running active pods, each once
+func podNodeNames(pods []v1.Pod) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for i := range pods {
+		name := pods[i].Spec.NodeName
+		if name == "" || seen[name] || !isActivePod(&pods[i]) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
 }
 
-func printPodTable(w *tabwriter.Writer, podsList podInfoList) {
+// isCrashLooping reports whether any container of the pod is waiting in CrashLoopBackOff
+func isCrashLooping(pod *v1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+			return true
+		}
+	}
+	return false
+}
+
+// usageCell renders usage with its percentage of the request, or plain when
+// there is no request to relate it to
+func usageCell(usage, request *resource.Quantity) string {
+	if usage == nil {
+		return plainCell("<none>")
+	}
+	val, suffix := usage.CanonicalizeBytes(make([]byte, 0, 100))
+	if request == nil || request.IsZero() {
+		return plainCell(string(val) + string(suffix))
+	}
+	percentage := float64(usage.MilliValue()) / float64(request.MilliValue()) * 100
+	return percentCell(string(val)+string(suffix), percentage, "%.0f%%")
+}
+
+func printPodTable(w *tabwriter.Writer, cols []podColumn, podsList podInfoList) {
 	// Print headers
-	fmt.Fprintln(w, strings.Join(getPodRowValues(podInfo{}, true), "\t"))
+	fmt.Fprintln(w, strings.Join(getPodRowValues(cols, podInfo{}, true), "\t"))
 
 	// Print rows
 	for _, pod := range podsList {
-		fmt.Fprintln(w, strings.Join(getPodRowValues(pod, false), "\t"))
+		fmt.Fprintln(w, strings.Join(getPodRowValues(cols, pod, false), "\t"))
 	}
 	w.Flush()
 }
 
-func getPodRowValues(pod podInfo, isHeader bool) []string {
-	values := make([]string, len(podColumns))
-	for i, col := range podColumns {
-		if isHeader {
+func getPodRowValues(cols []podColumn, pod podInfo, isHeader bool) []string {
+	values := make([]string, len(cols))
+	for i, col := range cols {
+		switch {
+		case isHeader && col.colored:
+			values[i] = plainCell(col.header)
+		case isHeader:
 			values[i] = col.header
-		} else {
+		default:
 			values[i] = col.getter(pod)
 		}
 	}
@@ -250,33 +571,16 @@ func init() {
 	resourceKeys := []string{"cpuReq", "cpuLimit", "cpuUsage (%)", "memReq", "memLimit", "memUsage (%)"}
 	for _, key := range resourceKeys {
 		col := podColumn{
+			id:     toColumnID(key),
 			header: toPodColumnName(key),
 			getter: func(key string) func(pod podInfo) string {
 				return func(pod podInfo) string {
 					var quantity *resource.Quantity
 					switch key {
 					case "cpuUsage (%)":
-						if pod.cpuUsage == nil {
-							return "<none>"
-						}
-						if pod.resources["cpuReq"] == nil || pod.resources["cpuReq"].IsZero() {
-							val, suffix := pod.cpuUsage.CanonicalizeBytes(make([]byte, 0, 100))
-							return string(val) + string(suffix)
-						}
-						percentage := float64(pod.cpuUsage.MilliValue()) / float64(pod.resources["cpuReq"].MilliValue()) * 100
-						val, suffix := pod.cpuUsage.CanonicalizeBytes(make([]byte, 0, 100))
-						return fmt.Sprintf("%s%s (%.0f%%)", string(val), string(suffix), percentage)
+						return usageCell(pod.cpuUsage, pod.resources["cpuReq"])
 					case "memUsage (%)":
-						if pod.memUsage == nil {
-							return "<none>"
-						}
-						if pod.resources["memReq"] == nil || pod.resources["memReq"].IsZero() {
-							val, suffix := pod.memUsage.CanonicalizeBytes(make([]byte, 0, 100))
-							return string(val) + string(suffix)
-						}
-						percentage := float64(pod.memUsage.Value()) / float64(pod.resources["memReq"].Value()) * 100
-						val, suffix := pod.memUsage.CanonicalizeBytes(make([]byte, 0, 100))
-						return fmt.Sprintf("%s%s (%.0f%%)", string(val), string(suffix), percentage)
+						return usageCell(pod.memUsage, pod.resources["memReq"])
 					default:
 						quantity = pod.resources[key]
 						if quantity == nil {
@@ -287,14 +591,30 @@ func init() {
 					}
 				}
 			}(key),
+			colored: strings.HasSuffix(key, "(%)"),
 		}
-		podColumns = append(podColumns, col)
+		podColumnCatalog = append(podColumnCatalog, col)
+	}
+	podColumnCatalog = append(podColumnCatalog, podNetColumns...)
+	podColumnCatalog = append(podColumnCatalog, podCadvisorColumns...)
+	podColumnCatalog = append(podColumnCatalog, podRequestsColumn, podQoSColumn, podNodeColumn, podWorkloadColumn, podVPATargetColumn)
+	podColumnCatalog = append(podColumnCatalog, podCostColumns...)
+	podColumnCatalog = append(podColumnCatalog, podClusterColumn)
+
+	var podColumnIDs []string
+	for _, col := range podColumnCatalog {
+		podColumnIDs = append(podColumnIDs, col.id)
 	}
 
 	rootCmd.AddCommand(podsCmd)
-	podsCmd.Flags().StringVar(&podSortBy, "sort-by", "name", "Sort pods by: name, cpu-req, cpu-limit, mem-req, mem-limit")
+	podsCmd.Flags().StringVar(&podSortBy, "sort-by", "name", "Sort pods by: name, cpu-req, cpu-limit, mem-req, mem-limit, net-rx, net-tx, throttled")
 	podsCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Show pods in the specified namespace")
-	podsCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show additional columns like NODE")
+	podsCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show additional columns like NODE (same as adding node to the default --columns)")
 	podsCmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "Show pods from all namespaces")
+	addCostFlags(podsCmd)
+	addDisplayFlags(podsCmd)
+	addColumnFlags(podsCmd, podColumnIDs)
+	addSampleIntervalFlag(podsCmd)
+	addThrottleThresholdFlag(podsCmd)
 
 }
@@ -4,8 +4,11 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
+	client "github.com/akomic/kubectl-xtop/client"
 	"github.com/spf13/cobra"
 )
 
@@ -14,8 +17,13 @@ var (
 	Sha      string
 	FileName string
 	debug    bool
+	fromFile string
+	fromDir  string
 )
 
+// dataSource is where commands read cluster state from, set up before any command runs
+var dataSource client.Source
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "xtop",
@@ -23,6 +31,59 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+		return initDataSource(cmd)
+	},
+}
+
+// initDataSource picks a snapshot file, a dump directory, the live cluster or,
+// with --contexts/--all-contexts, one source per kubeconfig context
+func initDataSource(cmd *cobra.Command) error {
+	if len(contextNames) > 0 || allContexts {
+		return initClusterSources(cmd)
+	}
+
+	switch {
+	case fromFile != "" && fromDir != "":
+		return fmt.Errorf("--from-file and --from-dir cannot be combined")
+	case fromFile != "":
+		snapshot, err := client.LoadSnapshot(fromFile)
+		if err != nil {
+			return err
+		}
+		dataSource = client.NewSnapshotSource(snapshot)
+	case fromDir != "":
+		snapshot, err := client.LoadDump(fromDir)
+		if err != nil {
+			return err
+		}
+		dataSource = client.NewSnapshotSource(snapshot)
+	default:
+		if err := client.Init(); err != nil {
+			return err
+		}
+		source, err := client.NewLiveSource()
+		if err != nil {
+			return err
+		}
+		dataSource = source
+	}
+	return nil
+}
+
+// loadSnapshotPath reads a snapshot file, or a dump directory when path is a directory
+func loadSnapshotPath(path string) (*client.Snapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return client.LoadDump(path)
+	}
+	return client.LoadSnapshot(path)
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -30,10 +91,16 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug output")
+	rootCmd.PersistentFlags().StringVar(&fromFile, "from-file", "", "read cluster state from a snapshot saved with 'xtop snapshot save' instead of the live cluster")
+	rootCmd.PersistentFlags().StringVar(&fromDir, "from-dir", "", "read cluster state from a directory of 'kubectl get -o yaml' dumps instead of the live cluster")
 }
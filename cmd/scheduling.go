@@ -0,0 +1,290 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// gpuResource is the extended resource name used for GPU accounting
+const gpuResource = v1.ResourceName("nvidia.com/gpu")
+
+// schedulingResources are the resources checked when deciding whether a pod fits a node
+var schedulingResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory, gpuResource}
+
+// nodeAllocation tracks what has already been placed on a node
+type nodeAllocation struct {
+	node      *v1.Node
+	requested v1.ResourceList
+	pods      int
+}
+
+func newNodeAllocation(node *v1.Node) *nodeAllocation {
+	return &nodeAllocation{
+		node:      node,
+		requested: v1.ResourceList{},
+	}
+}
+
+func (a *nodeAllocation) add(requests v1.ResourceList) {
+	for name, val := range requests {
+		sum := a.requested[name]
+		sum.Add(val)
+		a.requested[name] = sum
+	}
+	a.pods++
+}
+
+// free returns the allocatable amount of a resource that is not requested yet
+func (a *nodeAllocation) free(name v1.ResourceName) resource.Quantity {
+	free := a.node.Status.Allocatable[name].DeepCopy()
+	free.Sub(a.requested[name])
+	return free
+}
+
+func (a *nodeAllocation) freePods() int64 {
+	return a.node.Status.Allocatable.Pods().Value() - int64(a.pods)
+}
+
+// buildNodeAllocations sums the requests of all active pods per node
+func buildNodeAllocations(nodes []v1.Node, pods []v1.Pod) map[string]*nodeAllocation {
+	allocations := make(map[string]*nodeAllocation, len(nodes))
+	for i := range nodes {
+		allocations[nodes[i].Name] = newNodeAllocation(&nodes[i])
+	}
+	for i := range pods {
+		if !isActivePod(&pods[i]) {
+			continue
+		}
+		if alloc, ok := allocations[pods[i].Spec.NodeName]; ok {
+			alloc.add(podRequests(&pods[i].Spec))
+		}
+	}
+	return allocations
+}
+
+// isActivePod reports whether a pod still holds resources on its node
+func isActivePod(pod *v1.Pod) bool {
+	return pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed
+}
+
+// podRequests returns the effective requests of a pod the way the scheduler
+// computes them: the sum of app containers, raised to the largest init
+// container, plus pod overhead.
+func podRequests(spec *v1.PodSpec) v1.ResourceList {
+	requests := v1.ResourceList{}
+	for _, container := range spec.Containers {
+		for name, val := range container.Resources.Requests {
+			sum := requests[name]
+			sum.Add(val)
+			requests[name] = sum
+		}
+	}
+	for _, container := range spec.InitContainers {
+		for name, val := range container.Resources.Requests {
+			if current, ok := requests[name]; !ok || val.Cmp(current) > 0 {
+				requests[name] = val.DeepCopy()
+			}
+		}
+	}
+	for name, val := range spec.Overhead {
+		sum := requests[name]
+		sum.Add(val)
+		requests[name] = sum
+	}
+	return requests
+}
+
+// isNodeReady reports whether the node has a true Ready condition
+func isNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// placementReasons returns why a pod cannot be placed on a node, ignoring
+// resources. An empty result means the node is eligible.
+func placementReasons(node *v1.Node, spec *v1.PodSpec) []string {
+	var reasons []string
+
+	if node.Spec.Unschedulable {
+		reasons = append(reasons, "cordoned")
+	}
+	if !isNodeReady(node) {
+		reasons = append(reasons, "not ready")
+	}
+	return append(reasons, constraintReasons(node, spec)...)
+}
+
+// constraintReasons returns why the taints and labels of a node rule it out
+// for a pod, regardless of the node's state
+func constraintReasons(node *v1.Node, spec *v1.PodSpec) []string {
+	var reasons []string
+
+	if taint := untoleratedTaint(node.Spec.Taints, spec.Tolerations); taint != nil {
+		reasons = append(reasons, fmt.Sprintf("taint %s", taintString(taint)))
+	}
+	if !matchesNodeSelector(node, spec.NodeSelector) {
+		reasons = append(reasons, "nodeSelector mismatch")
+	}
+	if !matchesRequiredAffinity(node, spec.Affinity) {
+		reasons = append(reasons, "node affinity mismatch")
+	}
+	return reasons
+}
+
+// resourceReasons returns the resources a node lacks to run one more pod with the given requests
+func resourceReasons(alloc *nodeAllocation, requests v1.ResourceList) []string {
+	var reasons []string
+
+	if alloc.freePods() < 1 {
+		reasons = append(reasons, "max pods reached")
+	}
+	for _, name := range schedulingResources {
+		want, ok := requests[name]
+		if !ok || want.IsZero() {
+			continue
+		}
+		free := alloc.free(name)
+		if free.Cmp(want) < 0 {
+			reasons = append(reasons, fmt.Sprintf("insufficient %s", resourceLabel(name)))
+		}
+	}
+	return reasons
+}
+
+// replicasThatFit returns how many copies of a pod with the given requests fit into the free space of a node
+func replicasThatFit(alloc *nodeAllocation, requests v1.ResourceList) int64 {
+	count := alloc.freePods()
+	for _, name := range schedulingResources {
+		want, ok := requests[name]
+		if !ok || want.IsZero() {
+			continue
+		}
+		free := alloc.free(name)
+		n := free.MilliValue() / want.MilliValue()
+		if n < count {
+			count = n
+		}
+	}
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
+func resourceLabel(name v1.ResourceName) string {
+	switch name {
+	case gpuResource:
+		return "gpu"
+	default:
+		return string(name)
+	}
+}
+
+// untoleratedTaint returns the first NoSchedule/NoExecute taint that is not tolerated
+func untoleratedTaint(taints []v1.Taint, tolerations []v1.Toleration) *v1.Taint {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return taint
+		}
+	}
+	return nil
+}
+
+func taintString(taint *v1.Taint) string {
+	if taint.Value == "" {
+		return fmt.Sprintf("%s:%s", taint.Key, taint.Effect)
+	}
+	return fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect)
+}
+
+func matchesNodeSelector(node *v1.Node, nodeSelector map[string]string) bool {
+	if len(nodeSelector) == 0 {
+		return true
+	}
+	return labels.SelectorFromSet(nodeSelector).Matches(labels.Set(node.Labels))
+}
+
+// matchesRequiredAffinity evaluates requiredDuringSchedulingIgnoredDuringExecution node affinity.
+// Terms are ORed, expressions within a term are ANDed.
+func matchesRequiredAffinity(node *v1.Node, affinity *v1.Affinity) bool {
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for _, term := range terms {
+		if matchesNodeSelectorTerm(node, term) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesNodeSelectorTerm(node *v1.Node, term v1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, expr := range term.MatchExpressions {
+		if !matchesNodeSelectorRequirement(labels.Set(node.Labels), expr) {
+			return false
+		}
+	}
+	for _, expr := range term.MatchFields {
+		if !matchesNodeSelectorRequirement(labels.Set{"metadata.name": node.Name}, expr) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesNodeSelectorRequirement(set labels.Set, expr v1.NodeSelectorRequirement) bool {
+	var op selection.Operator
+	switch expr.Operator {
+	case v1.NodeSelectorOpIn:
+		op = selection.In
+	case v1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+	case v1.NodeSelectorOpExists:
+		op = selection.Exists
+	case v1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	case v1.NodeSelectorOpGt:
+		op = selection.GreaterThan
+	case v1.NodeSelectorOpLt:
+		op = selection.LessThan
+	default:
+		return false
+	}
+	req, err := labels.NewRequirement(expr.Key, op, expr.Values)
+	if err != nil {
+		return false
+	}
+	return req.Matches(set)
+}
+
+// formatQuantity renders a quantity the same way the node and pod tables do
+func formatQuantity(q resource.Quantity) string {
+	val, suffix := q.CanonicalizeBytes(make([]byte, 0, 100))
+	return string(val) + string(suffix)
+}
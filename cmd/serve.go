@@ -0,0 +1,328 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	serveAddress string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Expose allocation aggregates as Prometheus metrics",
+	Long: `Keep an informer-backed view of nodes, pods and ResourceQuotas and expose
+the aggregates xtop computes on /metrics in Prometheus text or OpenMetrics
+format: per-node requests and limits against allocatable, per-namespace
+requests against quota and per-workload requests. /healthz reports whether
+the informers have synced.
+
+Inside a cluster the pod's service account is used, otherwise the kubeconfig.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+		return client.InitInCluster()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServeCommand()
+	},
+}
+
+// exporter computes metrics from informer caches on every scrape
+type exporter struct {
+	factory informers.SharedInformerFactory
+	nodes   corelisters.NodeLister
+	pods    corelisters.PodLister
+	quotas  corelisters.ResourceQuotaLister
+	synced  []cache.InformerSynced
+}
+
+// metricFamily is one metric name with its samples
+type metricFamily struct {
+	name    string
+	help    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels []string // name, value pairs
+	value  float64
+}
+
+func runServeCommand() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	e := newExporter(client.Clientset)
+	e.start(ctx.Done())
+
+	server := &http.Server{Addr: serveAddress, Handler: e.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving metrics on %s/metrics\n", serveAddress)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func newExporter(clientset kubernetes.Interface) *exporter {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTransform(client.SlimObject))
+	e := &exporter{
+		factory: factory,
+		nodes:   factory.Core().V1().Nodes().Lister(),
+		pods:    factory.Core().V1().Pods().Lister(),
+		quotas:  factory.Core().V1().ResourceQuotas().Lister(),
+	}
+	e.synced = []cache.InformerSynced{
+		factory.Core().V1().Nodes().Informer().HasSynced,
+		factory.Core().V1().Pods().Informer().HasSynced,
+		factory.Core().V1().ResourceQuotas().Informer().HasSynced,
+	}
+	return e
+}
+
+func (e *exporter) start(stop <-chan struct{}) {
+	e.factory.Start(stop)
+}
+
+func (e *exporter) ready() bool {
+	for _, synced := range e.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *exporter) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !e.ready() {
+			http.Error(w, "informers not synced", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !e.ready() {
+			http.Error(w, "informers not synced", http.StatusServiceUnavailable)
+			return
+		}
+		families, err := e.collect()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		writeMetricFamilies(w, families, openMetrics)
+	})
+	return mux
+}
+
+// collect aggregates the cached objects the same way the nodes, namespaces and pods views do
+func (e *exporter) collect() ([]*metricFamily, error) {
+	nodePtrs, err := e.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	podPtrs, err := e.pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := e.quotas.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]v1.Node, 0, len(nodePtrs))
+	for _, node := range nodePtrs {
+		nodes = append(nodes, *node)
+	}
+	pods := make([]v1.Pod, 0, len(podPtrs))
+	for _, pod := range podPtrs {
+		pods = append(pods, *pod)
+	}
+	podsList := getPodInfoList(pods, nil)
+
+	nodeCapacity := &metricFamily{name: "xtop_node_capacity", help: "Node capacity by resource (cores, bytes)."}
+	nodeAllocatable := &metricFamily{name: "xtop_node_allocatable", help: "Node allocatable by resource (cores, bytes)."}
+	nodeRequests := &metricFamily{name: "xtop_node_requests", help: "Sum of container requests of pods on the node (cores, bytes)."}
+	nodeLimits := &metricFamily{name: "xtop_node_limits", help: "Sum of container limits of pods on the node (cores, bytes)."}
+	nodeRequestRatio := &metricFamily{name: "xtop_node_requests_allocatable_ratio", help: "Node requests as a fraction of allocatable."}
+	nodeLimitRatio := &metricFamily{name: "xtop_node_limits_allocatable_ratio", help: "Node limits as a fraction of allocatable."}
+
+	allocatable := make(map[string]v1.ResourceList, len(nodes))
+	for _, node := range nodes {
+		allocatable[node.Name] = node.Status.Allocatable
+	}
+	for _, node := range getNodeInfoList(nodes, pods) {
+		for _, r := range exportedResources {
+			alloc := allocatable[node.name][v1.ResourceName(r.name)]
+			sampleLabels := []string{"node", node.name, "resource", r.name}
+			nodeCapacity.add(sampleLabels, node.resources[r.prefix+"Capacity"].AsApproximateFloat64())
+			nodeAllocatable.add(sampleLabels, alloc.AsApproximateFloat64())
+			nodeRequests.add(sampleLabels, node.resources[r.prefix+"Req"].AsApproximateFloat64())
+			nodeLimits.add(sampleLabels, node.resources[r.prefix+"Limit"].AsApproximateFloat64())
+			nodeRequestRatio.add(sampleLabels, ratio(node.resources[r.prefix+"Req"], &alloc))
+			nodeLimitRatio.add(sampleLabels, ratio(node.resources[r.prefix+"Limit"], &alloc))
+		}
+	}
+
+	namespacePods := &metricFamily{name: "xtop_namespace_pods", help: "Number of active pods in the namespace."}
+	namespaceRequests := &metricFamily{name: "xtop_namespace_requests", help: "Sum of container requests of active pods in the namespace (cores, bytes)."}
+	namespaceLimits := &metricFamily{name: "xtop_namespace_limits", help: "Sum of container limits of active pods in the namespace (cores, bytes)."}
+	namespaces := getNamespaceInfoList(podsList)
+	namespacesByName := make(map[string]namespaceInfo, len(namespaces))
+	for _, ns := range namespaces {
+		namespacesByName[ns.name] = ns
+		namespacePods.add([]string{"namespace", ns.name}, float64(ns.pods))
+		for _, r := range exportedResources {
+			sampleLabels := []string{"namespace", ns.name, "resource", r.name}
+			namespaceRequests.add(sampleLabels, ns.resources[r.prefix+"Req"].AsApproximateFloat64())
+			namespaceLimits.add(sampleLabels, ns.resources[r.prefix+"Limit"].AsApproximateFloat64())
+		}
+	}
+
+	quotaHard := &metricFamily{name: "xtop_namespace_quota_hard", help: "ResourceQuota hard limit for requests and limits (cores, bytes)."}
+	quotaRatio := &metricFamily{name: "xtop_namespace_quota_ratio", help: "Requests or limits of active pods as a fraction of the ResourceQuota hard limit."}
+	for _, quota := range quotas {
+		ns := namespacesByName[quota.Namespace]
+		for name, hard := range quota.Status.Hard {
+			key, ok := quotaResourceKeys[name]
+			if !ok {
+				continue
+			}
+			sampleLabels := []string{"namespace", quota.Namespace, "quota", quota.Name, "resource", string(name)}
+			quotaHard.add(sampleLabels, hard.AsApproximateFloat64())
+			used := resource.NewQuantity(0, resource.DecimalSI)
+			if ns.resources != nil {
+				used = ns.resources[key]
+			}
+			quotaRatio.add(sampleLabels, ratio(used, &hard))
+		}
+	}
+
+	workloadPods := &metricFamily{name: "xtop_workload_pods", help: "Number of active pods of the workload."}
+	workloadRequests := &metricFamily{name: "xtop_workload_requests", help: "Sum of container requests of active pods of the workload (cores, bytes)."}
+	workloadLimits := &metricFamily{name: "xtop_workload_limits", help: "Sum of container limits of active pods of the workload (cores, bytes)."}
+	type workloadTotals struct {
+		pods      int
+		resources map[string]*resource.Quantity
+	}
+	workloads := make(map[workloadRef]*workloadTotals)
+	for _, pod := range podsList {
+		if pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+		wl, ok := workloads[pod.workload]
+		if !ok {
+			wl = &workloadTotals{resources: make(map[string]*resource.Quantity)}
+			workloads[pod.workload] = wl
+		}
+		wl.pods++
+		addResources(wl.resources, pod.resources)
+	}
+	for ref, wl := range workloads {
+		workloadPods.add([]string{"namespace", ref.namespace, "kind", ref.kind, "workload", ref.name}, float64(wl.pods))
+		for _, r := range exportedResources {
+			sampleLabels := []string{"namespace", ref.namespace, "kind", ref.kind, "workload", ref.name, "resource", r.name}
+			workloadRequests.add(sampleLabels, wl.resources[r.prefix+"Req"].AsApproximateFloat64())
+			workloadLimits.add(sampleLabels, wl.resources[r.prefix+"Limit"].AsApproximateFloat64())
+		}
+	}
+
+	return []*metricFamily{
+		nodeCapacity, nodeAllocatable, nodeRequests, nodeLimits, nodeRequestRatio, nodeLimitRatio,
+		namespacePods, namespaceRequests, namespaceLimits, quotaHard, quotaRatio,
+		workloadPods, workloadRequests, workloadLimits,
+	}, nil
+}
+
+// exportedResources pairs the resource label with the prefix of xtop's resource keys
+var exportedResources = []struct{ name, prefix string }{{"cpu", "cpu"}, {"memory", "mem"}}
+
+// quotaResourceKeys maps ResourceQuota resources to the namespace totals they bound
+var quotaResourceKeys = map[v1.ResourceName]string{
+	v1.ResourceCPU:            "cpuReq",
+	v1.ResourceMemory:         "memReq",
+	v1.ResourceRequestsCPU:    "cpuReq",
+	v1.ResourceRequestsMemory: "memReq",
+	v1.ResourceLimitsCPU:      "cpuLimit",
+	v1.ResourceLimitsMemory:   "memLimit",
+}
+
+func (f *metricFamily) add(labels []string, value float64) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// writeMetricFamilies writes gauges in the Prometheus text format, which
+// OpenMetrics only extends with the trailing EOF marker for gauges
+func writeMetricFamilies(out io.Writer, families []*metricFamily, openMetrics bool) error {
+	w := bufio.NewWriter(out)
+	for _, family := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", family.name)
+
+		lines := make([]string, 0, len(family.samples))
+		for _, sample := range family.samples {
+			pairs := make([]string, 0, len(sample.labels)/2)
+			for i := 0; i+1 < len(sample.labels); i += 2 {
+				pairs = append(pairs, sample.labels[i]+`="`+escapeLabelValue(sample.labels[i+1])+`"`)
+			}
+			lines = append(lines, family.name+"{"+strings.Join(pairs, ",")+"} "+strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+	return w.Flush()
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddress, "listen-address", ":9731", "Address to serve /metrics and /healthz on")
+}
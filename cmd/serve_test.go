@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServeScrape(t *testing.T) {
+	isController := true
+	clientset := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Status: v1.NodeStatus{
+				Capacity:    v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("16Gi")},
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("16Gi")},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-5d8f7c9b6-abcde",
+				Namespace: "shop",
+				Labels:    map[string]string{"pod-template-hash": "5d8f7c9b6"},
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "ReplicaSet", Name: "web-5d8f7c9b6", Controller: &isController},
+				},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node-a",
+				Containers: []v1.Container{{
+					Name: "web",
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("4Gi")},
+						Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("8Gi")},
+					},
+				}},
+			},
+			Status: v1.PodStatus{Phase: v1.PodRunning},
+		},
+		&v1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "shop"},
+			Status: v1.ResourceQuotaStatus{
+				Hard: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("4")},
+			},
+		},
+	)
+
+	e := newExporter(clientset)
+	stop := make(chan struct{})
+	defer close(stop)
+	e.start(stop)
+
+	server := httptest.NewServer(e.handler())
+	defer server.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(server.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("/healthz still returns %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(body)
+
+	for _, want := range []string{
+		`# TYPE xtop_node_requests gauge`,
+		`xtop_node_requests{node="node-a",resource="cpu"} 1`,
+		`xtop_node_requests_allocatable_ratio{node="node-a",resource="cpu"} 0.25`,
+		`xtop_node_limits_allocatable_ratio{node="node-a",resource="memory"} 0.5`,
+		`xtop_namespace_requests{namespace="shop",resource="memory"} 4.294967296e+09`,
+		`xtop_namespace_quota_ratio{namespace="shop",quota="compute",resource="requests.cpu"} 0.25`,
+		`xtop_workload_pods{namespace="shop",kind="Deployment",workload="web"} 1`,
+		`xtop_workload_limits{namespace="shop",kind="Deployment",workload="web",resource="cpu"} 2`,
+		"# EOF\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("scrape is missing %q\n%s", want, metrics)
+		}
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/openmetrics-text") {
+		t.Errorf("Content-Type is %q", got)
+	}
+}
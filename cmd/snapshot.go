@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotFile string
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save cluster state for offline analysis",
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save",
+	Short: "Capture cluster state and metrics into one file",
+	Long: `Capture nodes, pods, PodDisruptionBudgets, LimitRanges,
+HorizontalPodAutoscalers, workloads, VerticalPodAutoscalers, FailedScheduling
+events, PersistentVolumeClaims, kubelet configs and stats, cAdvisor metrics
+and metrics-server data into a single JSON file. Kinds that cannot be read are
+left out with a warning; only nodes and pods are required. Any command can
+then run against the file with --from-file. Combined with --from-dir this also
+converts kubectl dumps into a snapshot.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSnapshotSaveCommand()
+	},
+}
+
+func runSnapshotSaveCommand() error {
+	// Commands run against the snapshot may read any field, e.g. with
+	// -o custom-columns, so keep objects as the API returned them
+	client.FullObjects = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	snapshot, err := client.CaptureSnapshot(ctx, dataSource)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Save(snapshotFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved %d nodes and %d pods to %s\n", len(snapshot.Nodes), len(snapshot.Pods), snapshotFile)
+	if snapshot.PodMetrics == nil {
+		fmt.Println("Warning: metrics were not available and are not included")
+	}
+	for _, err := range snapshot.Skipped {
+		fmt.Printf("Warning: %v; not included\n", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotSaveCmd.Flags().StringVarP(&snapshotFile, "filename", "f", "", "File to write the snapshot to")
+	snapshotSaveCmd.MarkFlagRequired("filename")
+}
@@ -0,0 +1,310 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	volumesNamespace      string
+	volumesAllNamespaces  bool
+	volumesFullThreshold  float64
+	volumesInodeThreshold float64
+)
+
+var volumesCmd = &cobra.Command{
+	Use:   "volumes",
+	Short: "PersistentVolumeClaims with their capacity and actual usage",
+	Long: `List PersistentVolumeClaims with their capacity, storage class, access modes
+and the pods and nodes that mount them, next to the used, available and inode
+figures the kubelet reports for the mounted volume (/stats/summary). Claims
+that no running pod mounts have no usage figures.
+
+The ISSUES column flags volumes used beyond --full-threshold percent of their
+capacity, volumes that used more than --inode-threshold percent of their
+inodes, claims that are not bound and bound claims that no pod mounts.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVolumesCommand()
+	},
+}
+
+type volumeColumn struct {
+	header string
+	getter func(volumeInfo) string
+	// colored columns paint every cell, so their header is painted too
+	colored bool
+}
+
+type volumeInfo struct {
+	pvc *v1.PersistentVolumeClaim
+	// pods are the active pods mounting the claim, nodes the nodes they run on
+	pods  []string
+	nodes []string
+	// stats is the kubelet's view of the mounted volume, nil when unknown
+	stats  *client.VolumeStats
+	issues []string
+}
+
+var volumeColumns = []volumeColumn{
+	{header: "NAMESPACE", getter: func(v volumeInfo) string { return v.pvc.Namespace }},
+	{header: "NAME", getter: func(v volumeInfo) string { return v.pvc.Name }},
+	{header: "STATUS", getter: func(v volumeInfo) string { return string(v.pvc.Status.Phase) }},
+	{header: "STORAGECLASS", getter: func(v volumeInfo) string {
+		if v.pvc.Spec.StorageClassName == nil || *v.pvc.Spec.StorageClassName == "" {
+			return "<none>"
+		}
+		return *v.pvc.Spec.StorageClassName
+	}},
+	{header: "ACCESS MODES", getter: func(v volumeInfo) string { return formatAccessModes(v.pvc) }},
+	{header: "CAPACITY", getter: func(v volumeInfo) string { return claimCapacity(v.pvc) }},
+	{header: "USED (%)", getter: func(v volumeInfo) string {
+		if v.stats == nil || v.stats.UsedBytes == nil {
+			return plainCell("<unknown>")
+		}
+		used := formatBytes(float64(*v.stats.UsedBytes))
+		percent, ok := statsPercent(v.stats.UsedBytes, v.stats.CapacityBytes)
+		if !ok {
+			return plainCell(used)
+		}
+		return percentCell(used, percent, "%.0f%%")
+	}, colored: true},
+	{header: "AVAILABLE", getter: func(v volumeInfo) string {
+		if v.stats == nil || v.stats.AvailableBytes == nil {
+			return "<unknown>"
+		}
+		return formatBytes(float64(*v.stats.AvailableBytes))
+	}},
+	{header: "INODES (%)", getter: func(v volumeInfo) string {
+		if v.stats == nil || v.stats.InodesUsed == nil {
+			return plainCell("<unknown>")
+		}
+		used := fmt.Sprintf("%d", *v.stats.InodesUsed)
+		percent, ok := statsPercent(v.stats.InodesUsed, v.stats.Inodes)
+		if !ok {
+			return plainCell(used)
+		}
+		return percentCell(used, percent, "%.0f%%")
+	}, colored: true},
+	{header: "PODS", getter: func(v volumeInfo) string { return listOrNone(v.pods) }},
+	{header: "NODE", getter: func(v volumeInfo) string { return listOrNone(v.nodes) }},
+	{header: "ISSUES", getter: func(v volumeInfo) string {
+		if len(v.issues) == 0 {
+			return plainCell("-")
+		}
+		return paint(colorRed, strings.Join(v.issues, ", "))
+	}, colored: true},
+}
+
+func runVolumesCommand() error {
+	if err := validateDisplayFlags(); err != nil {
+		return err
+	}
+
+	volumesNs := volumesNamespace
+	if volumesAllNamespaces {
+		volumesNs = ""
+	} else if volumesNs == "" {
+		volumesNs = dataSource.DefaultNamespace()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{
+		Pods:                   true,
+		Namespace:              volumesNs,
+		PersistentVolumeClaims: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	volumes := getVolumeInfoList(state.PersistentVolumeClaims, state.Pods)
+	summaries := sampleKubeletStats(ctx, "", dataSource, volumeNodeNames(volumes))
+	applyVolumeStats(volumes, summaries)
+	sort.Slice(volumes, func(i, j int) bool {
+		if volumes[i].pvc.Namespace != volumes[j].pvc.Namespace {
+			return volumes[i].pvc.Namespace < volumes[j].pvc.Namespace
+		}
+		return volumes[i].pvc.Name < volumes[j].pvc.Name
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	headers := make([]string, len(volumeColumns))
+	for i, col := range volumeColumns {
+		headers[i] = col.header
+		if col.colored {
+			headers[i] = plainCell(col.header)
+		}
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, v := range volumes {
+		values := make([]string, len(volumeColumns))
+		for i, col := range volumeColumns {
+			values[i] = col.getter(v)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+	return nil
+}
+
+// getVolumeInfoList finds the active pods mounting every claim
+func getVolumeInfoList(pvcs []v1.PersistentVolumeClaim, pods []v1.Pod) []volumeInfo {
+	mounts := make(map[string][]*v1.Pod)
+	for i := range pods {
+		pod := &pods[i]
+		if !isActivePod(pod) {
+			continue
+		}
+		for _, claim := range podClaimNames(pod) {
+			key := pod.Namespace + "/" + claim
+			mounts[key] = append(mounts[key], pod)
+		}
+	}
+
+	volumes := make([]volumeInfo, 0, len(pvcs))
+	for i := range pvcs {
+		info := volumeInfo{pvc: &pvcs[i]}
+		nodes := make(map[string]bool)
+		for _, pod := range mounts[pvcs[i].Namespace+"/"+pvcs[i].Name] {
+			info.pods = append(info.pods, pod.Name)
+			if pod.Spec.NodeName != "" {
+				nodes[pod.Spec.NodeName] = true
+			}
+		}
+		sort.Strings(info.pods)
+		info.nodes = sortedKeys(nodes)
+		volumes = append(volumes, info)
+	}
+	return volumes
+}
+
+// podClaimNames returns the claims a pod mounts, including the claims of
+// generic ephemeral volumes, which are named after the pod and volume
+func podClaimNames(pod *v1.Pod) []string {
+	var claims []string
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+		case volume.Ephemeral != nil:
+			claims = append(claims, pod.Name+"-"+volume.Name)
+		}
+	}
+	return claims
+}
+
+// volumeNodeNames returns the nodes that have a claim mounted, whose kubelet stats are needed
+func volumeNodeNames(volumes []volumeInfo) []string {
+	nodes := make(map[string]bool)
+	for _, v := range volumes {
+		for _, node := range v.nodes {
+			nodes[node] = true
+		}
+	}
+	return sortedKeys(nodes)
+}
+
+// applyVolumeStats attaches the kubelet volume stats to the claims and flags
+// the issues of every claim
+func applyVolumeStats(volumes []volumeInfo, summaries map[string]*client.StatsSummary) {
+	stats := make(map[string]*client.VolumeStats)
+	for _, summary := range summaries {
+		for _, pod := range summary.Pods {
+			for i := range pod.Volumes {
+				ref := pod.Volumes[i].PVCRef
+				if ref != nil {
+					stats[ref.Namespace+"/"+ref.Name] = &pod.Volumes[i]
+				}
+			}
+		}
+	}
+
+	for i := range volumes {
+		v := &volumes[i]
+		v.stats = stats[v.pvc.Namespace+"/"+v.pvc.Name]
+		switch {
+		case v.pvc.Status.Phase != v1.ClaimBound:
+			v.issues = append(v.issues, "unbound")
+		case len(v.pods) == 0:
+			v.issues = append(v.issues, "orphaned")
+		}
+		if v.stats == nil {
+			continue
+		}
+		if percent, ok := statsPercent(v.stats.UsedBytes, v.stats.CapacityBytes); ok && percent >= volumesFullThreshold {
+			v.issues = append(v.issues, fmt.Sprintf("%.0f%% full", percent))
+		}
+		if percent, ok := statsPercent(v.stats.InodesUsed, v.stats.Inodes); ok && percent >= volumesInodeThreshold {
+			v.issues = append(v.issues, fmt.Sprintf("%.0f%% inodes used", percent))
+		}
+	}
+}
+
+// statsPercent returns used as a percentage of total, if the kubelet reported both
+func statsPercent(used, total *uint64) (float64, bool) {
+	if used == nil || total == nil || *total == 0 {
+		return 0, false
+	}
+	return float64(*used) / float64(*total) * 100, true
+}
+
+// claimCapacity is the capacity of the bound volume, or the requested size of an unbound claim
+func claimCapacity(pvc *v1.PersistentVolumeClaim) string {
+	if capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok {
+		return formatQuantity(capacity)
+	}
+	if request, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+		return formatQuantity(request)
+	}
+	return "<unknown>"
+}
+
+// formatAccessModes abbreviates access modes the way kubectl does, e.g. RWO,ROX
+func formatAccessModes(pvc *v1.PersistentVolumeClaim) string {
+	modes := pvc.Status.AccessModes
+	if len(modes) == 0 {
+		modes = pvc.Spec.AccessModes
+	}
+	abbreviations := map[v1.PersistentVolumeAccessMode]string{
+		v1.ReadWriteOnce:    "RWO",
+		v1.ReadOnlyMany:     "ROX",
+		v1.ReadWriteMany:    "RWX",
+		v1.ReadWriteOncePod: "RWOP",
+	}
+	var short []string
+	for _, mode := range modes {
+		if abbreviation, ok := abbreviations[mode]; ok {
+			short = append(short, abbreviation)
+		} else {
+			short = append(short, string(mode))
+		}
+	}
+	return listOrNone(short)
+}
+
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ",")
+}
+
+func init() {
+	rootCmd.AddCommand(volumesCmd)
+	volumesCmd.Flags().StringVarP(&volumesNamespace, "namespace", "n", "", "Show claims in the specified namespace")
+	volumesCmd.Flags().BoolVarP(&volumesAllNamespaces, "all-namespaces", "A", false, "Show claims from all namespaces")
+	volumesCmd.Flags().Float64Var(&volumesFullThreshold, "full-threshold", 80, "Flag volumes using at least this percentage of their capacity")
+	volumesCmd.Flags().Float64Var(&volumesInodeThreshold, "inode-threshold", 90, "Flag volumes using at least this percentage of their inodes")
+}
@@ -0,0 +1,358 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	vpaNamespace     string
+	vpaAllNamespaces bool
+	vpaDelta         bool
+)
+
+var vpaCmd = &cobra.Command{
+	Use:   "vpa",
+	Short: "VerticalPodAutoscaler recommendations next to current requests",
+	Long: `List the container recommendations of VerticalPodAutoscalers
+(autoscaling.k8s.io/v1) next to the requests of the pods they target. VPAs are
+read through the dynamic client, so clusters without the VPA CRD simply have
+none. Requests and recommendations are per replica.
+
+With --delta, show per namespace how requests would change if every
+recommendation were applied to the active pods it covers.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVPACommand()
+	},
+}
+
+// vpaContainer is one container recommendation with the requests of a replica
+type vpaContainer struct {
+	vpa            *client.VerticalPodAutoscaler
+	recommendation client.ContainerRecommendation
+	pods           int
+	// requests are nil when the target has no active pods
+	requests v1.ResourceList
+}
+
+// vpaNamespaceDelta sums requests and recommended targets of the containers covered by a VPA
+type vpaNamespaceDelta struct {
+	name      string
+	vpas      int
+	pods      int
+	resources map[string]*resource.Quantity
+}
+
+var vpaColumns = []struct {
+	header string
+	getter func(vpaContainer) string
+}{
+	{"NAMESPACE", func(c vpaContainer) string { return c.vpa.Namespace }},
+	{"NAME", func(c vpaContainer) string { return c.vpa.Name }},
+	{"REFERENCE", func(c vpaContainer) string { return c.vpa.TargetKind + "/" + c.vpa.TargetName }},
+	{"MODE", func(c vpaContainer) string { return c.vpa.UpdateMode }},
+	{"CONTAINER", func(c vpaContainer) string { return c.recommendation.ContainerName }},
+	{"PODS", func(c vpaContainer) string { return fmt.Sprintf("%d", c.pods) }},
+	{"CPU REQ", func(c vpaContainer) string { return requestValue(c.requests, v1.ResourceCPU) }},
+	{"CPU TARGET", func(c vpaContainer) string { return listValue(c.recommendation.Target, v1.ResourceCPU) }},
+	{"CPU RANGE", func(c vpaContainer) string {
+		return listValue(c.recommendation.LowerBound, v1.ResourceCPU) + "-" + listValue(c.recommendation.UpperBound, v1.ResourceCPU)
+	}},
+	{"MEM REQ", func(c vpaContainer) string { return requestValue(c.requests, v1.ResourceMemory) }},
+	{"MEM TARGET", func(c vpaContainer) string { return listValue(c.recommendation.Target, v1.ResourceMemory) }},
+	{"MEM RANGE", func(c vpaContainer) string {
+		return listValue(c.recommendation.LowerBound, v1.ResourceMemory) + "-" + listValue(c.recommendation.UpperBound, v1.ResourceMemory)
+	}},
+}
+
+// podVPATargetColumn is the VPA TARGET column of the pods command
+var podVPATargetColumn = podColumn{
+	id:     "vpa-target",
+	header: "VPA TARGET",
+	getter: func(pod podInfo) string {
+		if pod.vpaTarget == nil {
+			return "-"
+		}
+		return "cpu: " + listValue(pod.vpaTarget, v1.ResourceCPU) + ", mem: " + listValue(pod.vpaTarget, v1.ResourceMemory)
+	},
+}
+
+func runVPACommand() error {
+	if err := validateDisplayFlags(); err != nil {
+		return err
+	}
+
+	vpaNs := vpaNamespace
+	if vpaAllNamespaces {
+		vpaNs = ""
+	} else if vpaNs == "" {
+		vpaNs = dataSource.DefaultNamespace()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{
+		Pods:                   true,
+		Namespace:              vpaNs,
+		VerticalPodAutoscalers: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	if vpaDelta {
+		printVPADeltas(w, getVPANamespaceDeltas(state))
+	} else {
+		printVPAContainers(w, getVPAContainers(state))
+	}
+	w.Flush()
+	return nil
+}
+
+// activePodsByWorkload groups the pods that are not finished by their workload
+func activePodsByWorkload(pods []v1.Pod) map[workloadRef][]*v1.Pod {
+	byWorkload := make(map[workloadRef][]*v1.Pod)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		ref := podWorkload(pod)
+		byWorkload[ref] = append(byWorkload[ref], pod)
+	}
+	return byWorkload
+}
+
+func vpaTarget(vpa *client.VerticalPodAutoscaler) workloadRef {
+	return workloadRef{namespace: vpa.Namespace, kind: vpa.TargetKind, name: vpa.TargetName}
+}
+
+// vpasByWorkload indexes VPAs by the workload they target
+func vpasByWorkload(vpas []client.VerticalPodAutoscaler) map[workloadRef]*client.VerticalPodAutoscaler {
+	byWorkload := make(map[workloadRef]*client.VerticalPodAutoscaler, len(vpas))
+	for i := range vpas {
+		byWorkload[vpaTarget(&vpas[i])] = &vpas[i]
+	}
+	return byWorkload
+}
+
+// containerRecommendation returns the recommendation for a container of the pod
+func containerRecommendation(vpa *client.VerticalPodAutoscaler, container string) (client.ContainerRecommendation, bool) {
+	for _, rec := range vpa.Recommendations {
+		if rec.ContainerName == container {
+			return rec, true
+		}
+	}
+	return client.ContainerRecommendation{}, false
+}
+
+// podVPATarget sums the recommended targets of the containers of a pod, or
+// returns nil when the VPA has no recommendation for any of them
+func podVPATarget(pod *v1.Pod, vpa *client.VerticalPodAutoscaler) v1.ResourceList {
+	var target v1.ResourceList
+	for _, container := range pod.Spec.Containers {
+		rec, ok := containerRecommendation(vpa, container.Name)
+		if !ok {
+			continue
+		}
+		if target == nil {
+			target = v1.ResourceList{}
+		}
+		for name, q := range rec.Target {
+			sum := target[name]
+			sum.Add(q)
+			target[name] = sum
+		}
+	}
+	return target
+}
+
+// setVPATargets attaches the recommended targets to the pods their VPA covers
+func setVPATargets(podsList podInfoList, vpas []client.VerticalPodAutoscaler) {
+	byWorkload := vpasByWorkload(vpas)
+	for i := range podsList {
+		if vpa, ok := byWorkload[podsList[i].workload]; ok && podsList[i].object != nil {
+			podsList[i].vpaTarget = podVPATarget(podsList[i].object, vpa)
+		}
+	}
+}
+
+// getVPAContainers lists every container recommendation with the requests of the first active pod of the target
+func getVPAContainers(state *client.ClusterState) []vpaContainer {
+	podsByWorkload := activePodsByWorkload(state.Pods)
+
+	var containers []vpaContainer
+	for i := range state.VerticalPodAutoscalers {
+		vpa := &state.VerticalPodAutoscalers[i]
+		pods := podsByWorkload[vpaTarget(vpa)]
+		for _, rec := range vpa.Recommendations {
+			c := vpaContainer{vpa: vpa, recommendation: rec, pods: len(pods)}
+			if len(pods) > 0 {
+				c.requests = v1.ResourceList{}
+				for _, container := range pods[0].Spec.Containers {
+					if container.Name == rec.ContainerName {
+						c.requests = container.Resources.Requests
+					}
+				}
+			}
+			containers = append(containers, c)
+		}
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		a, b := containers[i], containers[j]
+		if a.vpa.Namespace != b.vpa.Namespace {
+			return a.vpa.Namespace < b.vpa.Namespace
+		}
+		if a.vpa.Name != b.vpa.Name {
+			return a.vpa.Name < b.vpa.Name
+		}
+		return a.recommendation.ContainerName < b.recommendation.ContainerName
+	})
+	return containers
+}
+
+// getVPANamespaceDeltas sums, per namespace, the requests of the containers a
+// VPA recommends for and what they would be with the recommendations applied.
+// A resource the VPA does not recommend for keeps its request.
+func getVPANamespaceDeltas(state *client.ClusterState) []vpaNamespaceDelta {
+	podsByWorkload := activePodsByWorkload(state.Pods)
+
+	byNamespace := make(map[string]*vpaNamespaceDelta)
+	for i := range state.VerticalPodAutoscalers {
+		vpa := &state.VerticalPodAutoscalers[i]
+		delta, ok := byNamespace[vpa.Namespace]
+		if !ok {
+			delta = &vpaNamespaceDelta{name: vpa.Namespace, resources: newVPADeltaResources()}
+			byNamespace[vpa.Namespace] = delta
+		}
+		delta.vpas++
+
+		for _, pod := range podsByWorkload[vpaTarget(vpa)] {
+			delta.pods++
+			for _, container := range pod.Spec.Containers {
+				rec, ok := containerRecommendation(vpa, container.Name)
+				if !ok {
+					continue
+				}
+				for name, prefix := range map[v1.ResourceName]string{v1.ResourceCPU: "cpu", v1.ResourceMemory: "mem"} {
+					request := container.Resources.Requests[name]
+					target, ok := rec.Target[name]
+					if !ok {
+						target = request
+					}
+					delta.resources[prefix+"Req"].Add(request)
+					delta.resources[prefix+"Target"].Add(target)
+				}
+			}
+		}
+	}
+
+	deltas := make([]vpaNamespaceDelta, 0, len(byNamespace)+1)
+	total := vpaNamespaceDelta{name: totalLabel, resources: newVPADeltaResources()}
+	for _, name := range sortedKeys(byNamespace) {
+		delta := byNamespace[name]
+		deltas = append(deltas, *delta)
+		total.vpas += delta.vpas
+		total.pods += delta.pods
+		addResources(total.resources, delta.resources)
+	}
+	if len(deltas) > 1 {
+		deltas = append(deltas, total)
+	}
+	return deltas
+}
+
+func newVPADeltaResources() map[string]*resource.Quantity {
+	return map[string]*resource.Quantity{
+		"cpuReq":    resource.NewQuantity(0, resource.DecimalSI),
+		"cpuTarget": resource.NewQuantity(0, resource.DecimalSI),
+		"memReq":    resource.NewQuantity(0, resource.BinarySI),
+		"memTarget": resource.NewQuantity(0, resource.BinarySI),
+	}
+}
+
+func printVPAContainers(w *tabwriter.Writer, containers []vpaContainer) {
+	headers := make([]string, len(vpaColumns))
+	for i, col := range vpaColumns {
+		headers[i] = col.header
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, c := range containers {
+		values := make([]string, len(vpaColumns))
+		for i, col := range vpaColumns {
+			values[i] = col.getter(c)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+}
+
+func printVPADeltas(w *tabwriter.Writer, deltas []vpaNamespaceDelta) {
+	fmt.Fprintln(w, strings.Join([]string{"NAMESPACE", "VPAS", "PODS", "CPU REQ", "CPU TARGET", plainCell("CPU DELTA"), "MEM REQ", "MEM TARGET", plainCell("MEM DELTA")}, "\t"))
+	for _, delta := range deltas {
+		fmt.Fprintln(w, strings.Join([]string{
+			delta.name,
+			fmt.Sprintf("%d", delta.vpas),
+			fmt.Sprintf("%d", delta.pods),
+			formatQuantity(*delta.resources["cpuReq"]),
+			formatQuantity(*delta.resources["cpuTarget"]),
+			deltaCell(delta.resources["cpuReq"], delta.resources["cpuTarget"]),
+			formatQuantity(*delta.resources["memReq"]),
+			formatQuantity(*delta.resources["memTarget"]),
+			deltaCell(delta.resources["memReq"], delta.resources["memTarget"]),
+		}, "\t"))
+	}
+}
+
+// deltaCell renders target minus request with a sign: yellow when requests
+// would grow, green when they would shrink
+func deltaCell(request, target *resource.Quantity) string {
+	delta := target.DeepCopy()
+	delta.Sub(*request)
+	switch delta.Sign() {
+	case 1:
+		return paint(colorYellow, "+"+formatQuantity(delta))
+	case -1:
+		return paint(colorGreen, formatQuantity(delta))
+	default:
+		return plainCell("0")
+	}
+}
+
+// listValue renders one resource of a list, or <none> when it is not set
+func listValue(list v1.ResourceList, name v1.ResourceName) string {
+	q, ok := list[name]
+	if !ok {
+		return "<none>"
+	}
+	return formatQuantity(q)
+}
+
+// requestValue is listValue for the requests of a target that may have no pods
+func requestValue(requests v1.ResourceList, name v1.ResourceName) string {
+	if requests == nil {
+		return "-"
+	}
+	return listValue(requests, name)
+}
+
+func init() {
+	rootCmd.AddCommand(vpaCmd)
+	vpaCmd.Flags().StringVarP(&vpaNamespace, "namespace", "n", "", "Show VPAs in the specified namespace")
+	vpaCmd.Flags().BoolVarP(&vpaAllNamespaces, "all-namespaces", "A", false, "Show VPAs from all namespaces")
+	vpaCmd.Flags().BoolVar(&vpaDelta, "delta", false, "Show per namespace how requests change if all recommendations were applied")
+	addDisplayFlags(vpaCmd)
+}
@@ -0,0 +1,348 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	client "github.com/akomic/kubectl-xtop/client"
+	"github.com/spf13/cobra"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	webListen  string
+	webRefresh time.Duration
+	webNetwork bool
+)
+
+//go:embed web/index.html
+var dashboardHTML []byte
+
+var webCmd = &cobra.Command{
+	Use:   "web",
+	Short: "Serve a web dashboard of nodes, pods and namespaces",
+	Long: `Serve a self-contained dashboard with sortable node, pod and namespace tables
+and per-node utilisation bars. It refreshes itself over server-sent events.
+
+The dashboard is backed by a JSON API whose cells are rendered by the same
+columns as the nodes, pods and namespaces commands:
+
+  /api/snapshot   nodes, pods and namespaces in one document
+  /api/nodes      /api/pods      /api/namespaces
+  /api/events     server-sent events with a new snapshot on every refresh
+
+With --network the node and pod tables get NET RX and NET TX, computed from the
+kubelet stats of consecutive refreshes.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runWebCommand()
+	},
+}
+
+// webTable is a table as the CLI prints it
+type webTable struct {
+	Columns []string `json:"columns"`
+	Rows    []webRow `json:"rows"`
+}
+
+type webRow struct {
+	Cells []string `json:"cells"`
+	// Utilisation is the fraction of node capacity requested and used, for nodes only
+	Utilisation map[string]float64 `json:"utilisation,omitempty"`
+}
+
+type webSnapshot struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	Error       string    `json:"error,omitempty"`
+	Nodes       webTable  `json:"nodes"`
+	Pods        webTable  `json:"pods"`
+	Namespaces  webTable  `json:"namespaces"`
+}
+
+// dashboard keeps the latest snapshot and pushes new ones to event subscribers
+type dashboard struct {
+	mu          sync.Mutex
+	snapshot    *webSnapshot
+	subscribers map[chan *webSnapshot]struct{}
+	// netStats is the kubelet stats sample of the previous refresh, for --network
+	netStats map[string]*client.StatsSummary
+}
+
+func runWebCommand() error {
+	// Cells are rendered by the CLI columns, which must not carry ANSI codes here
+	colorEnabled = false
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := &dashboard{subscribers: make(map[chan *webSnapshot]struct{})}
+	d.refresh()
+	go d.run(ctx, webRefresh)
+
+	server := &http.Server{Addr: webListen, Handler: d.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving dashboard on %s\n", webListen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (d *dashboard) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.refresh()
+		}
+	}
+}
+
+// refresh builds a new snapshot. When fetching fails the previous tables are
+// kept and the error is reported alongside them.
+func (d *dashboard) refresh() {
+	d.mu.Lock()
+	previousStats := d.netStats
+	d.mu.Unlock()
+
+	snapshot, netStats, err := buildWebSnapshot(previousStats)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if netStats != nil {
+		d.netStats = netStats
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not refresh dashboard: %v\n", err)
+		if d.snapshot == nil {
+			snapshot = &webSnapshot{}
+		} else {
+			previous := *d.snapshot
+			snapshot = &previous
+		}
+		snapshot.Error = err.Error()
+	}
+	d.snapshot = snapshot
+	for ch := range d.subscribers {
+		select {
+		case ch <- snapshot:
+		default:
+			// A slow client skips this update and gets the next one
+		}
+	}
+}
+
+func (d *dashboard) latest() *webSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot
+}
+
+func (d *dashboard) subscribe() chan *webSnapshot {
+	ch := make(chan *webSnapshot, 1)
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch
+}
+
+func (d *dashboard) unsubscribe(ch chan *webSnapshot) {
+	d.mu.Lock()
+	delete(d.subscribers, ch)
+	d.mu.Unlock()
+}
+
+func (d *dashboard) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.latest())
+	})
+	mux.HandleFunc("/api/nodes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.latest().Nodes)
+	})
+	mux.HandleFunc("/api/pods", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.latest().Pods)
+	})
+	mux.HandleFunc("/api/namespaces", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.latest().Namespaces)
+	})
+	mux.HandleFunc("/api/events", d.serveEvents)
+	return mux
+}
+
+// serveEvents streams the current snapshot and every following one
+func (d *dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := d.subscribe()
+	defer d.unsubscribe(ch)
+
+	snapshot := d.latest()
+	for {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", data)
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case snapshot = <-ch:
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// buildWebSnapshot aggregates the cluster with the same code and columns as
+// the nodes, pods and namespaces commands. With --network it also returns the
+// kubelet stats it sampled, and computes rates against the previous sample.
+func buildWebSnapshot(previousStats map[string]*client.StatsSummary) (*webSnapshot, map[string]*client.StatsSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	state, err := client.FetchState(ctx, dataSource, client.FetchRequest{Nodes: true, Pods: true, PodMetrics: true, LimitRanges: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot := &webSnapshot{GeneratedAt: time.Now().UTC()}
+	var warnings []string
+	if state.MetricsErr != nil {
+		warnings = append(warnings, fmt.Sprintf("metrics not available: %v", state.MetricsErr))
+	}
+	if state.LimitRangesErr != nil {
+		warnings = append(warnings, fmt.Sprintf("LimitRanges not available: %v", state.LimitRangesErr))
+	}
+	snapshot.Error = strings.Join(warnings, "; ")
+
+	nodesList := getNodeInfoList(state.Nodes, state.Pods)
+	podsList := getPodInfoList(state.Pods, state.PodMetrics)
+	setRequestCoverage(podsList, requestCoverageByPod(state.Pods, namespaceContainerDefaults(state.LimitRanges)))
+	namespacesList := getNamespaceInfoList(podsList)
+	sort.Sort(nodesList)
+	sort.Sort(podsList)
+	sort.Sort(namespacesList)
+
+	nodeColumnIDs := defaultNodeColumns
+	podColumnIDs := append(append([]string{}, defaultPodColumns...), podNodeColumn.id)
+	var netStats map[string]*client.StatsSummary
+	if webNetwork {
+		names := make([]string, len(state.Nodes))
+		for i, node := range state.Nodes {
+			names[i] = node.Name
+		}
+		netStats = sampleKubeletStats(ctx, "", dataSource, names)
+		nodeRates, podRates := networkRates(previousStats, netStats)
+		applyNodeNetRates(nodesList, nodeRates)
+		applyPodNetRates(podsList, podRates)
+		nodeColumnIDs = append(append([]string{}, nodeColumnIDs...), netColumnIDs...)
+		podColumnIDs = append(podColumnIDs, netColumnIDs...)
+	}
+
+	// Node usage is the sum of the usage of its pods
+	usage := make(map[string]map[string]*resource.Quantity)
+	for _, pod := range podsList {
+		if pod.phase == "Succeeded" || pod.phase == "Failed" {
+			continue
+		}
+		if usage[pod.nodeName] == nil {
+			usage[pod.nodeName] = make(map[string]*resource.Quantity)
+		}
+		addResources(usage[pod.nodeName], map[string]*resource.Quantity{"cpu": pod.cpuUsage, "mem": pod.memUsage})
+	}
+
+	nodeCols, err := selectColumns(nodeColumnCatalog, func(col column) string { return col.id }, nodeColumnIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, col := range nodeCols {
+		snapshot.Nodes.Columns = append(snapshot.Nodes.Columns, col.header)
+	}
+	for _, node := range nodesList {
+		row := webRow{Cells: getRowValues(nodeCols, node, false), Utilisation: make(map[string]float64)}
+		for _, prefix := range []string{"cpu", "mem"} {
+			capacity := node.resources[prefix+"Capacity"]
+			row.Utilisation[prefix+"Req"] = ratio(node.resources[prefix+"Req"], capacity)
+			if state.MetricsErr == nil {
+				row.Utilisation[prefix+"Usage"] = ratio(usage[node.name][prefix], capacity)
+			}
+		}
+		snapshot.Nodes.Rows = append(snapshot.Nodes.Rows, row)
+	}
+
+	podTableColumns, err := selectColumns(podColumnCatalog, func(col podColumn) string { return col.id }, podColumnIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, col := range podTableColumns {
+		snapshot.Pods.Columns = append(snapshot.Pods.Columns, col.header)
+	}
+	for _, pod := range podsList {
+		row := webRow{}
+		for _, col := range podTableColumns {
+			row.Cells = append(row.Cells, col.getter(pod))
+		}
+		snapshot.Pods.Rows = append(snapshot.Pods.Rows, row)
+	}
+
+	namespaceCols := namespaceTableColumns(false)
+	for _, col := range namespaceCols {
+		snapshot.Namespaces.Columns = append(snapshot.Namespaces.Columns, col.header)
+	}
+	for _, ns := range namespacesList {
+		row := webRow{}
+		for _, col := range namespaceCols {
+			row.Cells = append(row.Cells, col.getter(ns))
+		}
+		snapshot.Namespaces.Rows = append(snapshot.Namespaces.Rows, row)
+	}
+	return snapshot, netStats, nil
+}
+
+func init() {
+	rootCmd.AddCommand(webCmd)
+	webCmd.Flags().StringVar(&webListen, "listen", ":8080", "Address to serve the dashboard on")
+	webCmd.Flags().DurationVar(&webRefresh, "refresh", 10*time.Second, "How often the dashboard data is refreshed")
+	webCmd.Flags().BoolVar(&webNetwork, "network", false, "Add network throughput measured between refreshes, from the kubelet stats of every node")
+}
@@ -0,0 +1,42 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadRef identifies the top level controller of a pod
+type workloadRef struct {
+	namespace string
+	kind      string
+	name      string
+}
+
+func (w workloadRef) String() string {
+	return w.namespace + "/" + w.kind + "/" + w.name
+}
+
+// podWorkload returns the workload a pod belongs to. ReplicaSets created by a
+// Deployment are resolved to the Deployment through the pod-template-hash
+// label, and bare pods are their own workload.
+func podWorkload(pod *v1.Pod) workloadRef {
+	ref := workloadRef{namespace: pod.Namespace, kind: "Pod", name: pod.Name}
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ref
+	}
+	ref.kind, ref.name = owner.Kind, owner.Name
+
+	if owner.Kind == "ReplicaSet" {
+		if hash, ok := pod.Labels["pod-template-hash"]; ok && strings.HasSuffix(owner.Name, "-"+hash) {
+			ref.kind, ref.name = "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+	return ref
+}